@@ -3,75 +3,288 @@ package triesbien
 import (
 	"context"
 	"encoding/binary"
+	"time"
 
 	"github.com/QubitProducts/triesbien/trie"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb"
+	"golang.org/x/sync/errgroup"
 )
 
-func WriteLevelDB(ctx context.Context, dbPath string, productChan <-chan string) error {
+func WriteLevelDB(ctx context.Context, dbPath string, config Config, productChan <-chan Document) error {
 	db, err := leveldb.OpenFile(dbPath, nil)
 	if err != nil {
 		return errors.Wrap(err, "could not open leveldb")
 	}
 	defer db.Close()
 
-	var i uint32
+	return WriteDocuments(ctx, LevelDBStore{DB: db}, config, productChan)
+}
+
+// WriteDocuments stores each document in store under the same id as
+// BuildTrie gives it.
+func WriteDocuments(ctx context.Context, store DocumentStore, config Config, productChan <-chan Document) error {
+	var i uint64
 	for ; ; i++ {
-		var item string
+		var doc Document
 		var ok bool
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case item, ok = <-productChan:
+		case doc, ok = <-productChan:
 		}
 		if !ok {
 			break
 		}
 
-		err := db.Put(toBS(i), []byte(item), nil)
+		id, err := documentID(config, doc, i)
 		if err != nil {
-			return errors.Wrap(err, "could not write to leveldb")
+			return err
+		}
+		if err := store.Put(id, doc.Text); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func BuildTrie(ctx context.Context, trie *trie.Trie, config Config, catalogueChan <-chan string) error {
-	var i uint32
+// BuildTrie adds each document to index under its id. A *trie.Trie is then
+// merged upwards and compressed ready for querying.
+func BuildTrie(ctx context.Context, index IndexWriter, config Config, catalogueChan <-chan Document) error {
+	var i uint64
 	for ; ; i++ {
-		var item string
+		var doc Document
 		var ok bool
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case item, ok = <-catalogueChan:
+		case doc, ok = <-catalogueChan:
 		}
 		if !ok {
 			break
 		}
 
-		parts := config.Parser(item)
-		glog.V(2).Infof("item: %v", item)
-		for i := range parts {
-			if len(parts[i]) > config.MaxLexemeLength {
-				glog.V(2).Infof("truncating %v", parts[i])
-				parts[i] = parts[i][0:config.MaxLexemeLength]
+		id, err := documentID(config, doc, i)
+		if err != nil {
+			return err
+		}
+		indexDocument(index, config, doc, id)
+	}
+
+	if t, ok := index.(*trie.Trie); ok {
+		return finishTrie(t, config, i)
+	}
+	return nil
+}
+
+// BuildTrieParallel builds the same trie as BuildTrie, but spreads the
+// documents over workers shards that are filled concurrently and then
+// merged.
+func BuildTrieParallel(ctx context.Context, t *trie.Trie, config Config, workers int, catalogueChan <-chan Document) error {
+	if workers < 1 {
+		workers = 1
+	}
+	type item struct {
+		doc Document
+		id  uint64
+	}
+
+	grp, ctx := errgroup.WithContext(ctx)
+	shards := make([]*trie.Trie, workers)
+	shardChans := make([]chan item, workers)
+	for w := range shards {
+		shard := trie.NewTrie()
+		shardChan := make(chan item, 64)
+		shards[w] = shard
+		shardChans[w] = shardChan
+		grp.Go(func() error {
+			for it := range shardChan {
+				indexDocument(shard, config, it.doc, it.id)
+			}
+			return nil
+		})
+	}
+
+	// documents are dealt out in turn, so that with ids numbered by
+	// position every shard still sees them in ascending order
+	var count uint64
+	grp.Go(func() error {
+		defer func() {
+			for _, c := range shardChans {
+				close(c)
+			}
+		}()
+		for ; ; count++ {
+			var doc Document
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case doc, ok = <-catalogueChan:
+			}
+			if !ok {
+				return nil
+			}
+
+			id, err := documentID(config, doc, count)
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case shardChans[int(count)%workers] <- item{doc: doc, id: id}:
 			}
-			glog.V(4).Infof("part - %v", parts[i])
 		}
+	})
+	if err := grp.Wait(); err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		if err := t.Merge(shard); err != nil {
+			return errors.Wrap(err, "could not merge shard")
+		}
+	}
+	return finishTrie(t, config, count)
+}
+
+// documentID returns the id doc is stored and indexed under: the one
+// config.IDs maps its key to or, without an IDMap, its position in the
+// feed.
+func documentID(config Config, doc Document, position uint64) (uint64, error) {
+	if config.IDs == nil {
+		return position, nil
+	}
+	if doc.Key == "" {
+		return 0, errors.Errorf("document %v has no key", position)
+	}
+	return config.IDs.ID(doc.Key), nil
+}
+
+func indexDocument(t IndexWriter, config Config, doc Document, id uint64) {
+	glog.V(2).Infof("item: %v", doc.Text)
+	if doc.Score != 0 {
+		t.SetScore(id, doc.Score)
+	}
+	ls, suffixes := indexTerms(config, doc.Text)
+	for _, lexeme := range ls {
+		t.Append(lexeme, id)
+	}
+	for _, suffix := range suffixes {
+		t.AppendSuffix(suffix, id)
+	}
+}
 
-		for _, part := range parts {
-			trie.Append([]rune(part), i)
+func finishTrie(t *trie.Trie, config Config, documentCount uint64) error {
+	meta := config.TrieMetadata()
+	meta.DocumentCount = documentCount
+	meta.BuiltAt = time.Now()
+	t.SetMetadata(meta)
+
+	if config.Postings != nil {
+		err := t.SaturatedPostings(config.MaxBucketLength, func(prefix []rune, entries []uint64) error {
+			return config.Postings.PutPostings(string(prefix), entries)
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not store saturated posting lists")
 		}
 	}
 
-	trie.MergeUpwards(config.MaxBucketLength)
+	t.MergeUpwards(config.MaxBucketLength)
+	t.Compress()
+	return nil
+}
+
+// AddDocument indexes doc under id in an already built trie and puts it in
+// docs, so that queries find it straight away.
+func AddDocument(t *trie.Trie, docs DocumentStore, config Config, doc Document, id uint64) error {
+	if err := docs.Put(id, doc.Text); err != nil {
+		return err
+	}
+	t.SetScore(id, doc.Score)
+	ls, suffixes := indexTerms(config, doc.Text)
+	t.AddDocument(ls, id)
+	t.AddSuffixes(suffixes, id)
+	if config.Postings != nil {
+		err := updatePostings(config.Postings, append(ls, suffixes...), id, true)
+		return errors.Wrap(err, "could not update posting lists")
+	}
 	return nil
 }
 
-func toBS(ix uint32) []byte {
+// RemoveDocument removes doc, stored under id, from the trie and docs.
+func RemoveDocument(t *trie.Trie, docs DocumentStore, config Config, doc Document, id uint64) error {
+	ls, suffixes := indexTerms(config, doc.Text)
+	t.RemoveDocument(ls, id)
+	t.RemoveSuffixes(suffixes, id)
+	if config.Postings != nil {
+		if err := updatePostings(config.Postings, append(ls, suffixes...), id, false); err != nil {
+			return errors.Wrap(err, "could not update posting lists")
+		}
+	}
+	return docs.Delete(id)
+}
+
+func lexemes(config Config, item string) [][]rune {
+	parts := config.Parser(item)
+	res := make([][]rune, len(parts))
+	for i := range parts {
+		if len(parts[i]) > config.MaxLexemeLength {
+			glog.V(2).Infof("truncating %v", parts[i])
+			parts[i] = parts[i][0:config.MaxLexemeLength]
+		}
+		glog.V(4).Infof("part - %v", parts[i])
+		res[i] = []rune(parts[i])
+	}
+	return res
+}
+
+// indexTerms returns the lexemes of item and, if config.IndexSuffixes is
+// set, the suffixes of them to index too. Suffixes are taken from the whole
+// part before truncation, and any that are also lexemes of item are left
+// out.
+func indexTerms(config Config, item string) ([][]rune, [][]rune) {
+	ls := lexemes(config, item)
+	if !config.IndexSuffixes {
+		return ls, nil
+	}
+	minLength := config.MinSuffixLength
+	if minLength < 1 {
+		minLength = 1
+	}
+
+	seen := make(map[string]bool, len(ls))
+	for _, l := range ls {
+		seen[string(l)] = true
+	}
+	suffixes := [][]rune{}
+	for _, part := range config.Parser(item) {
+		runes := []rune(part)
+		for i := 1; len(runes)-i >= minLength; i++ {
+			suffix := runes[i:]
+			if len(suffix) > config.MaxLexemeLength {
+				suffix = suffix[:config.MaxLexemeLength]
+			}
+			if seen[string(suffix)] {
+				continue
+			}
+			seen[string(suffix)] = true
+			suffixes = append(suffixes, suffix)
+		}
+	}
+	return ls, suffixes
+}
+
+func toBS(ix uint64) []byte {
+	ret := make([]byte, 8)
+	binary.LittleEndian.PutUint64(ret, ix)
+	return ret
+}
+
+// toBS32 is the key documents were stored under when ids were uint32s.
+func toBS32(ix uint32) []byte {
 	ret := make([]byte, 4)
 	binary.LittleEndian.PutUint32(ret, ix)
 	return ret
@@ -0,0 +1,182 @@
+package triesbien
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QubitProducts/triesbien/trie"
+)
+
+func testDocuments(n int) []Document {
+	rnd := rand.New(rand.NewSource(1))
+	words := []string{"red", "dress", "drill", "shirt", "tshirt", "shoe", "shorts", "blue", "xl", "jacket", "jeans"}
+	docs := make([]Document, n)
+	for i := range docs {
+		parts := make([]string, 1+rnd.Intn(4))
+		for j := range parts {
+			parts[j] = words[rnd.Intn(len(words))]
+		}
+		parts = append(parts, fmt.Sprintf("sku%d", i))
+		docs[i] = Document{Text: strings.Join(parts, " "), Score: float64(rnd.Intn(5))}
+	}
+	return docs
+}
+
+func testConfig() Config {
+	return Config{
+		Parser:          strings.Fields,
+		ParserName:      "fields",
+		MaxLexemeLength: 5,
+		MaxBucketLength: 8,
+	}
+}
+
+func documentChan(docs []Document) <-chan Document {
+	c := make(chan Document)
+	go func() {
+		defer close(c)
+		for _, d := range docs {
+			c <- d
+		}
+	}()
+	return c
+}
+
+// buildTestIndex writes docs to a new memory store and builds index from
+// them, returning the store.
+func buildTestIndex(t *testing.T, config Config, docs []Document, index IndexWriter) *MemoryStore {
+	store := NewMemoryStore()
+	if err := WriteDocuments(context.Background(), store, config, documentChan(docs)); err != nil {
+		t.Fatalf("could not write documents: %v", err)
+	}
+	if err := BuildTrie(context.Background(), index, config, documentChan(docs)); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	return store
+}
+
+func marshalTrie(t *testing.T, tr *trie.Trie) []byte {
+	meta := tr.Metadata()
+	meta.BuiltAt = time.Unix(0, 0)
+	tr.SetMetadata(meta)
+	buf := &bytes.Buffer{}
+	if err := tr.Marshal(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildTrieParallel(t *testing.T) {
+	t.Parallel()
+
+	docs := testDocuments(500)
+	config := testConfig()
+
+	sequential := trie.NewTrie()
+	if err := BuildTrie(context.Background(), sequential, config, documentChan(docs)); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	expected := marshalTrie(t, sequential)
+
+	for _, workers := range []int{1, 2, 3, 8} {
+		parallel := trie.NewTrie()
+		if err := BuildTrieParallel(context.Background(), parallel, config, workers, documentChan(docs)); err != nil {
+			t.Fatalf("parallel build failed: %v", err)
+		}
+		if got := marshalTrie(t, parallel); !bytes.Equal(got, expected) {
+			t.Errorf("trie built on %v workers differs from sequential build", workers)
+		}
+	}
+}
+
+func TestIndexSuffixes(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig()
+	config.IndexSuffixes = true
+	config.MinSuffixLength = 3
+
+	tr := trie.NewTrie()
+	docs := []Document{{Text: "tshirt"}, {Text: "shirt"}, {Text: "smartphone xl"}}
+	if err := BuildTrie(context.Background(), tr, config, documentChan(docs)); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	cases := []struct {
+		value    string
+		expected []uint64
+	}{
+		{value: "shirt", expected: []uint64{0, 1}},
+		{value: "phon", expected: []uint64{2}},
+		{value: "ts", expected: []uint64{0}},
+		{value: "rt", expected: []uint64{2}},
+		{value: "l", expected: nil},
+	}
+	for _, c := range cases {
+		if got := tr.Lookup([]rune(c.value)); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("unexpected result for %v\nGot: %v\nExpected: %v", c.value, got, c.expected)
+		}
+	}
+
+	completions := tr.Completions([]rune("sh"), 10)
+	if len(completions) != 1 || completions[0].Lexeme != "shirt" || completions[0].Documents != 1 {
+		t.Errorf("suffixes shouldn't count towards completions, got %v", completions)
+	}
+
+	data := marshalTrie(t, tr)
+	withoutSuffixes := trie.NewTrie()
+	withoutSuffixes.SetMetadata(testConfig().TrieMetadata())
+	if err := withoutSuffixes.Unmarshal(bytes.NewReader(data)); err == nil {
+		t.Errorf("expected a trie with suffixes to be rejected by a config without them")
+	}
+	loaded := trie.NewTrie()
+	loaded.SetMetadata(config.TrieMetadata())
+	if err := loaded.Unmarshal(bytes.NewReader(data)); err != nil {
+		t.Errorf("unmarshal failed: %v", err)
+	}
+}
+
+func TestBuildTrieExternal(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "external")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	docs := testDocuments(500)
+	for _, suffixes := range []bool{false, true} {
+		config := testConfig()
+		config.IndexSuffixes = suffixes
+		config.MinSuffixLength = 3
+
+		inMemory := trie.NewTrie()
+		if err := BuildTrie(context.Background(), inMemory, config, documentChan(docs)); err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		expected := marshalTrie(t, inMemory)
+
+		for _, budget := range []int{1 << 30, 4096} {
+			external := trie.NewTrie()
+			if err := BuildTrieExternal(context.Background(), external, config, dir, budget, documentChan(docs)); err != nil {
+				t.Fatalf("external build failed: %v", err)
+			}
+			if got := marshalTrie(t, external); !bytes.Equal(got, expected) {
+				t.Errorf("trie built with a budget of %v differs from in memory build", budget)
+			}
+		}
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected run files to be removed, found %v", len(files))
+	}
+}
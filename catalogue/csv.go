@@ -5,12 +5,18 @@ import (
 	"encoding/csv"
 	"io"
 	"os"
+	"strconv"
 
+	"github.com/QubitProducts/triesbien"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
 
-func CSVLoader(ctx context.Context, filename string, colIx int, rowChan chan<- string) error {
+// CSVLoader sends a document for every distinct value of column colIx in
+// the CSV file. If keyColIx isn't negative, documents are keyed by that
+// column instead and there is one for every distinct key. If scoreColIx
+// isn't negative, documents are scored from the number in that column.
+func CSVLoader(ctx context.Context, filename string, colIx int, keyColIx int, scoreColIx int, rowChan chan<- triesbien.Document) error {
 	defer close(rowChan)
 
 	file, err := os.Open(filename)
@@ -33,17 +39,37 @@ func CSVLoader(ctx context.Context, filename string, colIx int, rowChan chan<- s
 
 		if len(record) <= colIx {
 			glog.Errorf("line %v did not have column %v", i, colIx)
+			continue
+		}
+
+		doc := triesbien.Document{Text: record[colIx]}
+		key := doc.Text
+		if keyColIx >= 0 {
+			if len(record) <= keyColIx {
+				glog.Errorf("line %v did not have key column %v", i, keyColIx)
+				continue
+			}
+			doc.Key = record[keyColIx]
+			key = doc.Key
 		}
 
-		if _, ok := keys[record[colIx]]; ok {
+		if _, ok := keys[key]; ok {
 			continue
 		}
-		keys[record[colIx]] = true
+		keys[key] = true
+
+		if scoreColIx >= 0 {
+			if len(record) <= scoreColIx {
+				glog.Errorf("line %v did not have score column %v", i, scoreColIx)
+			} else if doc.Score, err = strconv.ParseFloat(record[scoreColIx], 64); err != nil {
+				glog.Errorf("line %v has invalid score %q", i, record[scoreColIx])
+			}
+		}
 
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case rowChan <- record[colIx]:
+		case rowChan <- doc:
 		}
 	}
 }
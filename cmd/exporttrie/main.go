@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/QubitProducts/triesbien/trie"
+	"github.com/golang/glog"
+)
+
+var (
+	triePath     = "./data/trie.pb"
+	exportPrefix = ""
+	exportDepth  = 2
+	exportFormat = "json"
+	exportIDs    = false
+)
+
+func init() {
+	flag.StringVar(&triePath, "trie.path", triePath, "path to read the trie from (pb format)")
+	flag.StringVar(&exportPrefix, "export.prefix", exportPrefix, "prefix whose subtree to export")
+	flag.IntVar(&exportDepth, "export.depth", exportDepth, "levels below the prefix to export (-1 for all)")
+	flag.StringVar(&exportFormat, "export.format", exportFormat, "format to export in, json or dot")
+	flag.BoolVar(&exportIDs, "export.ids", exportIDs, "include the document ids in each bucket")
+}
+
+func main() {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	format, err := trie.ParseExportFormat(exportFormat)
+	if err != nil {
+		glog.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	trieFile, err := os.Open(triePath)
+	if err != nil {
+		glog.Errorf("could not open trie path to read: %v", err)
+		os.Exit(1)
+	}
+	defer trieFile.Close()
+
+	t := trie.NewTrie()
+	if err := t.Unmarshal(trieFile); err != nil {
+		glog.Errorf("could not read trie: %v", err)
+		os.Exit(1)
+	}
+
+	if err := t.Export(os.Stdout, []rune(exportPrefix), exportDepth, format, exportIDs); err != nil {
+		glog.Errorf("could not export trie: %v", err)
+		os.Exit(1)
+	}
+}
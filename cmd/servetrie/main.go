@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 	"unicode"
 
 	"github.com/QubitProducts/triesbien"
 	"github.com/QubitProducts/triesbien/trie"
 	"github.com/golang/glog"
+	"github.com/pkg/errors"
 	"github.com/pressly/chi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -21,17 +27,39 @@ import (
 
 var (
 	triePath        = "./data/trie.pb"
+	trieFormat      = "pb"
+	trieVerify      = false
 	maxLexemeLength = 10
 	maxBucketLength = 1024
+	fuzzyMaxEdits   = 0
+	indexSuffixes   = false
+	minSuffixLength = 3
+	rank            = true
+	rankCandidates  = 1000
 	leveldbPath     = "./data/leveldb"
+	postingsPath    = ""
+	queryTimeout    = time.Second
+	pageLimit       = 10
+	maxPageLimit    = 100
 	addr            = ":3812"
 )
 
 func init() {
 	flag.IntVar(&maxLexemeLength, "search.lexeme-length", maxLexemeLength, "the maximum length of any lexeme")
 	flag.IntVar(&maxBucketLength, "search.bucket-length", maxBucketLength, "the maximum length of any bucket")
+	flag.IntVar(&fuzzyMaxEdits, "search.fuzzy-edits", fuzzyMaxEdits, "edit distance to fuzzy match query parts within when they aren't found (0 disables)")
+	flag.BoolVar(&indexSuffixes, "search.index-suffixes", indexSuffixes, "index the suffixes of lexemes so that query parts match within them")
+	flag.IntVar(&minSuffixLength, "search.min-suffix-length", minSuffixLength, "the minimum length of any indexed suffix")
+	flag.BoolVar(&rank, "search.rank", rank, "rank results, reading up to search.rank-candidates documents per query (if false, results are in index order and only the documents a page needs are read)")
+	flag.IntVar(&rankCandidates, "search.rank-candidates", rankCandidates, "the most candidates a ranked query reads, any beyond being left out (0 for no limit)")
 	flag.StringVar(&leveldbPath, "leveldb.path", leveldbPath, "path to the leveldb database")
+	flag.StringVar(&postingsPath, "postings.path", postingsPath, "path to the leveldb holding the full posting lists of saturated prefixes (empty disables)")
 	flag.StringVar(&triePath, "trie.path", triePath, "path to read/write trie from")
+	flag.StringVar(&trieFormat, "trie.format", trieFormat, "format of the trie file, pb, mapped or dawg")
+	flag.BoolVar(&trieVerify, "trie.verify", trieVerify, "check the checksum of the whole of a mapped trie when opening it, which reads every page of the file")
+	flag.DurationVar(&queryTimeout, "search.timeout", queryTimeout, "how long a query may run for: one still searching the index by then fails, one reading documents returns those read so far (0 for no limit)")
+	flag.IntVar(&pageLimit, "search.limit", pageLimit, "number of results to return when a query gives no limit")
+	flag.IntVar(&maxPageLimit, "search.max-limit", maxPageLimit, "the largest limit a query may give")
 	flag.StringVar(&addr, "addr", addr, "address to serve on")
 }
 
@@ -39,31 +67,73 @@ func main() {
 	flag.Set("logtostderr", "true")
 	flag.Parse()
 
-	t := trie.NewTrie()
 	config := triesbien.Config{
 		Parser:          parseProductTitle,
+		ParserName:      "product-title",
 		MaxLexemeLength: maxLexemeLength,
 		MaxBucketLength: maxBucketLength,
+		FuzzyMaxEdits:   fuzzyMaxEdits,
+		IndexSuffixes:   indexSuffixes,
+		MinSuffixLength: minSuffixLength,
 	}
-	trieFile, err := os.Open(triePath)
-	if err != nil {
-		glog.Errorf("could not open trie path to read: %v", err)
-		os.Exit(1)
+	if rank {
+		config.Ranking = triesbien.DefaultRanking
+		config.Ranking.MaxCandidates = rankCandidates
 	}
-	defer trieFile.Close()
 
-	err = t.Unmarshal(trieFile)
+	db, err := leveldb.OpenFile(leveldbPath, nil)
 	if err != nil {
-		glog.Errorf("could not read trie: %v", err)
+		glog.Errorf("could not open leveldb: %v", err)
 		os.Exit(1)
 	}
+	defer db.Close()
+	docs := triesbien.LevelDBStore{DB: db}
+
+	switch postingsPath {
+	case "":
+	case leveldbPath:
+		config.Postings = triesbien.LevelDBPostings{DB: db}
+	default:
+		postingsDB, err := leveldb.OpenFile(postingsPath, nil)
+		if err != nil {
+			glog.Errorf("could not open postings leveldb: %v", err)
+			os.Exit(1)
+		}
+		defer postingsDB.Close()
+		config.Postings = triesbien.LevelDBPostings{DB: postingsDB}
+	}
 
-	db, err := leveldb.OpenFile(leveldbPath, nil)
+	load := func() (*triesbien.Snapshot, error) {
+		t, closeTrie, err := loadTrie(config)
+		if err != nil {
+			return nil, err
+		}
+		return &triesbien.Snapshot{Index: t, Docs: docs, Config: config, Close: closeTrie}, nil
+	}
+	snap, err := load()
 	if err != nil {
-		glog.Errorf("could not open leveldb: %v", err)
+		glog.Errorf("could not read trie: %v", err)
 		os.Exit(1)
 	}
-	defer db.Close()
+	searcher := triesbien.NewSearcher(snap)
+	defer searcher.Close()
+
+	// SIGHUP reloads the trie from disk, say after it has been rebuilt,
+	// without dropping queries
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			started := time.Now()
+			snap, err := load()
+			if err != nil {
+				glog.Errorf("could not reload trie: %v", err)
+				continue
+			}
+			searcher.Swap(snap)
+			glog.Infof("reloaded trie in %v", time.Since(started))
+		}
+	}()
 
 	r := chi.NewRouter()
 
@@ -71,21 +141,63 @@ func main() {
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./cmd/servetrie/index.html")
 	})
+	r.Get("/_stats", func(w http.ResponseWriter, r *http.Request) {
+		snap, release := searcher.Acquire()
+		defer release()
+		tr, ok := snap.Index.(*trie.Trie)
+		if !ok {
+			w.WriteHeader(501)
+			fmt.Fprintf(w, "statistics aren't available for %v tries\n", trieFormat)
+			return
+		}
+		json.NewEncoder(w).Encode(tr.Stats())
+	})
 	r.Get("/:query", func(w http.ResponseWriter, r *http.Request) {
 		query := chi.URLParam(r, "query")
 
-		started := time.Now()
-		res, err := triesbien.Query(t, db, config, query)
+		page, err := parsePage(r)
 		if err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "bad page: %v\n", err)
+			return
+		}
+
+		ctx := r.Context()
+		if queryTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+			defer cancel()
+		}
+
+		started := time.Now()
+		res, err := searcher.Query(ctx, query, page)
+		switch {
+		case r.Context().Err() != nil:
+			glog.V(1).Infof("client went away after %v", time.Since(started))
+			return
+		case isParseError(err):
+			w.WriteHeader(400)
+			fmt.Fprintf(w, "bad query: %v\n", err)
+			return
+		case err == context.DeadlineExceeded:
+			w.WriteHeader(504)
+			fmt.Fprintf(w, "query timed out before any results were read\n")
+			return
+		case err != nil:
 			w.WriteHeader(500)
 			fmt.Fprintf(w, "query failed: %v\n", err)
+			return
 		}
 		glog.Infof("result in %v", time.Since(started))
 
-		if len(res) > 10 {
-			res = res[0:10]
+		resp := queryResponse{QueryResponse: res}
+		if res.NextOffset != 0 {
+			next := url.Values{}
+			next.Set("limit", strconv.Itoa(page.Limit))
+			next.Set("offset", strconv.Itoa(res.NextOffset))
+			resp.Next = (&url.URL{Path: r.URL.Path, RawQuery: next.Encode()}).String()
 		}
-		json.NewEncoder(w).Encode(res)
+		json.NewEncoder(w).Encode(resp)
 	})
 
 	glog.Infof("listening on %v", addr)
@@ -96,72 +208,83 @@ func main() {
 	}
 }
 
-func splitOn(r rune) bool {
-	return unicode.IsSpace(r) || unicode.IsSymbol(r) || unicode.IsPunct(r)
+// queryResponse adds the path of the next page of results, if there is
+// one, to a query's response.
+type queryResponse struct {
+	*triesbien.QueryResponse
+	Next string `json:"next,omitempty"`
 }
 
-var (
-	validPart = regexp.MustCompile(`^[a-z0-9]*([a-z]|[0-9]{2})[a-z0-9]*$`)
-)
-
-func parseProductTitle(title string) []string {
-	title = strings.ToLower(title)
-
-	lastSplit := 0
-
-	parts := []string{}
-
-	for i, r := range title {
-		if splitOn(r) {
-			part := title[lastSplit:i]
-			if validPart.MatchString(part) {
-				parts = append(parts, part)
-			}
+func isParseError(err error) bool {
+	_, ok := err.(*triesbien.ParseError)
+	return ok
+}
 
-			lastSplit = i + 1
+// parsePage reads the limit and offset parameters of a query, defaulting
+// to the first pageLimit results.
+func parsePage(r *http.Request) (triesbien.Page, error) {
+	page := triesbien.Page{Limit: pageLimit}
+	params := r.URL.Query()
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, errors.Errorf("limit must be between 1 and %v", maxPageLimit)
 		}
+		page.Limit = limit
 	}
-	if lastSplit != len(title) {
-		part := title[lastSplit:len(title)]
-		if validPart.MatchString(part) {
-			parts = append(parts, part)
+	if v := params.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return page, errors.New("offset must be a positive number")
 		}
+		page.Offset = offset
 	}
-	return parts
-}
-
-func splitOn(r rune) bool {
-	return unicode.IsSpace(r) || unicode.IsSymbol(r) || unicode.IsPunct(r)
+	return page, nil
 }
 
-var (
-	validPart = regexp.MustCompile(`^[a-z0-9]*([a-z]|[0-9]{2})[a-z0-9]*$`)
-)
-
-func parseProductTitle(title string) []string {
-	title = strings.ToLower(title)
-
-	lastSplit := 0
-
-	parts := []string{}
-
-	for i, r := range title {
-		if splitOn(r) {
-			part := title[lastSplit:i]
-			if validPart.MatchString(part) {
-				parts = append(parts, part)
+func loadTrie(config triesbien.Config) (triesbien.Index, func() error, error) {
+	switch trieFormat {
+	case "mapped":
+		m, err := trie.OpenMapped(triePath, config.TrieMetadata())
+		if err != nil {
+			return nil, nil, err
+		}
+		if trieVerify {
+			if err := m.Verify(); err != nil {
+				m.Close()
+				return nil, nil, err
 			}
+		}
+		return m, m.Close, nil
+	case "dawg":
+		dawgFile, err := os.Open(triePath)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not open trie path to read")
+		}
+		defer dawgFile.Close()
 
-			lastSplit = i + 1
+		d, err := trie.UnmarshalDAWG(dawgFile, config.TrieMetadata())
+		if err != nil {
+			return nil, nil, err
 		}
-	}
-	if lastSplit != len(title) {
-		part := title[lastSplit:len(title)]
-		if validPart.MatchString(part) {
-			parts = append(parts, part)
+		return d, func() error { return nil }, nil
+	case "pb":
+		trieFile, err := os.Open(triePath)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not open trie path to read")
 		}
+		defer trieFile.Close()
+
+		t := trie.NewTrie()
+		t.SetMetadata(config.TrieMetadata())
+		err = t.Unmarshal(trieFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return t, func() error { return nil }, nil
+	default:
+		return nil, nil, errors.Errorf("unknown trie format %v", trieFormat)
 	}
-	return parts
 }
 
 func splitOn(r rune) bool {
@@ -23,28 +23,64 @@ import (
 
 var (
 	searchQuery     = "tank"
+	searchLimit     = 0
+	searchOffset    = 0
 	trieWrite       = false
 	triePath        = "./data/trie.pb"
+	trieFormat      = "pb"
+	trieVerify      = false
+	triePack        = false
+	trieStats       = false
+	buildWorkers    = 1
+	buildMemory     = 0
+	buildDir        = ""
 	maxLexemeLength = 10
 	maxBucketLength = 1024
+	fuzzyMaxEdits   = 0
+	indexSuffixes   = false
+	minSuffixLength = 3
+	rank            = true
+	rankCandidates  = 1000
 	leveldbPath     = "./data/leveldb"
 	leveldbWrite    = false
+	postingsPath    = ""
 	cataloguePath   = ""
 	catalogueColumn = 1
+	keyColumn       = -1
+	idsPath         = ""
+	scoreColumn     = -1
 	cpuProfile      = ""
 	memProfile      = ""
 )
 
 func init() {
 	flag.StringVar(&searchQuery, "search.query", searchQuery, "the query to run")
+	flag.IntVar(&searchLimit, "search.limit", searchLimit, "number of results to print (0 for all)")
+	flag.IntVar(&searchOffset, "search.offset", searchOffset, "number of results to skip")
 	flag.IntVar(&maxLexemeLength, "search.lexeme-length", maxLexemeLength, "the maximum length of any lexeme")
 	flag.IntVar(&maxBucketLength, "search.bucket-length", maxBucketLength, "the maximum length of any bucket")
+	flag.IntVar(&fuzzyMaxEdits, "search.fuzzy-edits", fuzzyMaxEdits, "edit distance to fuzzy match query parts within when they aren't found (0 disables)")
+	flag.BoolVar(&indexSuffixes, "search.index-suffixes", indexSuffixes, "index the suffixes of lexemes so that query parts match within them")
+	flag.IntVar(&minSuffixLength, "search.min-suffix-length", minSuffixLength, "the minimum length of any indexed suffix")
+	flag.BoolVar(&rank, "search.rank", rank, "rank results, reading up to search.rank-candidates documents per query (if false, results are in index order and only the documents a page needs are read)")
+	flag.IntVar(&rankCandidates, "search.rank-candidates", rankCandidates, "the most candidates a ranked query reads, any beyond being left out (0 for no limit)")
 	flag.StringVar(&leveldbPath, "leveldb.path", leveldbPath, "path to the leveldb database")
 	flag.BoolVar(&leveldbWrite, "leveldb.write", leveldbWrite, "write the product index to leveldb")
+	flag.StringVar(&postingsPath, "postings.path", postingsPath, "path to a separate leveldb holding the full posting lists of saturated prefixes (empty disables)")
 	flag.BoolVar(&trieWrite, "trie.write", trieWrite, "write the trie to disk (load from disk if false)")
 	flag.StringVar(&triePath, "trie.path", triePath, "path to read/write trie from")
+	flag.StringVar(&trieFormat, "trie.format", trieFormat, "format of the trie file, pb, mapped or dawg")
+	flag.BoolVar(&trieVerify, "trie.verify", trieVerify, "check the checksum of the whole of a mapped trie when opening it, which reads every page of the file")
+	flag.IntVar(&buildWorkers, "trie.build-workers", buildWorkers, "number of shards to build the trie on concurrently")
+	flag.IntVar(&buildMemory, "trie.build-memory", buildMemory, "bytes of lexemes to hold in memory before spilling sorted runs to disk while building the trie (0 builds entirely in memory, and must be used to store full posting lists)")
+	flag.StringVar(&buildDir, "trie.build-dir", buildDir, "directory to spill sorted runs into (empty for the system temp dir)")
+	flag.BoolVar(&triePack, "trie.pack-postings", triePack, "delta and varint encode posting lists when writing a pb trie")
+	flag.BoolVar(&trieStats, "trie.stats", trieStats, "print statistics about the trie's shape")
 	flag.StringVar(&cataloguePath, "catalogue.path", cataloguePath, "path to the CSV dump of the catalogue")
 	flag.IntVar(&catalogueColumn, "catalogue.column", catalogueColumn, "column in the CSV catalogue to index")
+	flag.IntVar(&keyColumn, "catalogue.key-column", keyColumn, "column in the CSV catalogue holding each document's unique key (-1 to number documents by row)")
+	flag.StringVar(&idsPath, "ids.path", idsPath, "path to the map from document keys to ids, kept across builds (needs catalogue.key-column)")
+	flag.IntVar(&scoreColumn, "catalogue.score-column", scoreColumn, "column in the CSV catalogue holding document scores (-1 for none)")
 	flag.StringVar(&cpuProfile, "profile.cpu", cpuProfile, "file to dump the cpu profile into")
 	flag.StringVar(&memProfile, "profile.mem", memProfile, "file to dump the mem profile into")
 }
@@ -53,6 +89,11 @@ func main() {
 	flag.Set("logtostderr", "true")
 	flag.Parse()
 
+	if trieWrite && buildMemory > 0 && postingsPath != "" {
+		glog.Errorf("trie.build-memory can't be used with postings.path, as full posting lists can't be stored by an external build")
+		os.Exit(1)
+	}
+
 	if cpuProfile != "" {
 		f, err := os.Create(cpuProfile)
 		if err != nil {
@@ -64,37 +105,105 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	config := triesbien.Config{
+		Parser:          parseProductTitle,
+		ParserName:      "product-title",
+		MaxLexemeLength: maxLexemeLength,
+		MaxBucketLength: maxBucketLength,
+		FuzzyMaxEdits:   fuzzyMaxEdits,
+		IndexSuffixes:   indexSuffixes,
+		MinSuffixLength: minSuffixLength,
+	}
+	if rank {
+		config.Ranking = triesbien.DefaultRanking
+		config.Ranking.MaxCandidates = rankCandidates
+	}
+	if idsPath != "" {
+		ids, err := triesbien.LoadIDMap(idsPath)
+		if err != nil {
+			glog.Errorf("could not load id map: %v", err)
+			os.Exit(1)
+		}
+		config.IDs = ids
+	}
+
 	ctx := context.Background()
 
 	grp, ctx := errgroup.WithContext(ctx)
 	if leveldbWrite {
-		levelDBChan := make(chan string)
+		levelDBChan := make(chan triesbien.Document)
 		grp.Go(func() error {
-			err := catalogue.CSVLoader(ctx, cataloguePath, catalogueColumn, levelDBChan)
+			err := catalogue.CSVLoader(ctx, cataloguePath, catalogueColumn, keyColumn, scoreColumn, levelDBChan)
 			return errors.Wrap(err, "could not read catalogue for leveldb")
 		})
 		grp.Go(func() error {
-			err := triesbien.WriteLevelDB(ctx, leveldbPath, levelDBChan)
+			err := triesbien.WriteLevelDB(ctx, leveldbPath, config, levelDBChan)
 			return errors.Wrap(err, "could not write leveldb")
 		})
 	}
 
+	if trieFormat != "pb" && trieFormat != "mapped" && trieFormat != "dawg" {
+		glog.Errorf("unknown trie format %v", trieFormat)
+		os.Exit(1)
+	}
+
 	t := trie.NewTrie()
-	config := triesbien.Config{
-		Parser:          parseProductTitle,
-		MaxLexemeLength: maxLexemeLength,
-		MaxBucketLength: maxBucketLength,
+	var index triesbien.Index = t
+	if postingsPath != "" {
+		postingsDB, err := leveldb.OpenFile(postingsPath, nil)
+		if err != nil {
+			glog.Errorf("could not open postings leveldb: %v", err)
+			os.Exit(1)
+		}
+		defer postingsDB.Close()
+		config.Postings = triesbien.LevelDBPostings{DB: postingsDB}
 	}
 	if trieWrite {
-		trieChan := make(chan string)
+		trieChan := make(chan triesbien.Document)
 		grp.Go(func() error {
-			err := catalogue.CSVLoader(ctx, cataloguePath, catalogueColumn, trieChan)
+			err := catalogue.CSVLoader(ctx, cataloguePath, catalogueColumn, keyColumn, scoreColumn, trieChan)
 			return errors.Wrap(err, "could not read catalogue for trie")
 		})
 		grp.Go(func() error {
-			err := triesbien.BuildTrie(ctx, t, config, trieChan)
+			if buildMemory > 0 {
+				err := triesbien.BuildTrieExternal(ctx, t, config, buildDir, buildMemory, trieChan)
+				return errors.Wrap(err, "could not build trie")
+			}
+			err := triesbien.BuildTrieParallel(ctx, t, config, buildWorkers, trieChan)
 			return errors.Wrap(err, "could not build trie")
 		})
+	} else if trieFormat == "mapped" {
+		started := time.Now()
+		m, err := trie.OpenMapped(triePath, config.TrieMetadata())
+		if err != nil {
+			glog.Errorf("could not read trie: %v", err)
+			os.Exit(1)
+		}
+		defer m.Close()
+		if trieVerify {
+			if err := m.Verify(); err != nil {
+				glog.Errorf("could not verify trie: %v", err)
+				os.Exit(1)
+			}
+		}
+		index = m
+		glog.Infof("mapped trie in %v", time.Since(started))
+	} else if trieFormat == "dawg" {
+		started := time.Now()
+		dawgFile, err := os.Open(triePath)
+		if err != nil {
+			glog.Errorf("could not open trie path to read: %v", err)
+			os.Exit(1)
+		}
+		defer dawgFile.Close()
+
+		d, err := trie.UnmarshalDAWG(dawgFile, config.TrieMetadata())
+		if err != nil {
+			glog.Errorf("could not read trie: %v", err)
+			os.Exit(1)
+		}
+		index = d
+		glog.Infof("loaded DAWG of %v states in %v", d.States(), time.Since(started))
 	} else {
 		started := time.Now()
 		trieFile, err := os.Open(triePath)
@@ -104,12 +213,14 @@ func main() {
 		}
 		defer trieFile.Close()
 
+		t.SetMetadata(config.TrieMetadata())
 		err = t.Unmarshal(trieFile)
 		if err != nil {
 			glog.Errorf("could not read trie: %v", err)
 			os.Exit(1)
 		}
-		glog.Infof("loaded trie in %v", time.Since(started))
+		glog.Infof("loaded trie of %v documents built at %v in %v",
+			t.Metadata().DocumentCount, t.Metadata().BuiltAt, time.Since(started))
 	}
 
 	if err := grp.Wait(); err != nil {
@@ -117,6 +228,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	if config.IDs != nil && (leveldbWrite || trieWrite) {
+		if err := config.IDs.Save(idsPath); err != nil {
+			glog.Errorf("could not save id map: %v", err)
+			os.Exit(1)
+		}
+	}
+
 	if trieWrite {
 		trieFile, err := os.Create(triePath)
 		if err != nil {
@@ -125,13 +243,31 @@ func main() {
 		}
 		defer trieFile.Close()
 
-		err = t.Marshal(trieFile)
+		switch trieFormat {
+		case "mapped":
+			err = t.MarshalMapped(trieFile)
+		case "dawg":
+			err = trie.NewDAWG(t).Marshal(trieFile)
+		default:
+			if triePack {
+				t.PackPostings()
+			}
+			err = t.Marshal(trieFile)
+		}
 		if err != nil {
 			glog.Errorf("could not write trie: %v", err)
 			os.Exit(1)
 		}
 	}
 
+	if trieStats {
+		if tr, ok := index.(*trie.Trie); ok {
+			fmt.Print(tr.Stats())
+		} else {
+			glog.Errorf("statistics aren't available for %v tries", trieFormat)
+		}
+	}
+
 	db, err := leveldb.OpenFile(leveldbPath, nil)
 	if err != nil {
 		glog.Errorf("could not open leveldb: %v", err)
@@ -152,15 +288,17 @@ func main() {
 	}
 
 	started := time.Now()
-	res, err := triesbien.Query(t, db, config, searchQuery)
+	res, err := triesbien.Query(context.Background(), index, triesbien.LevelDBStore{DB: db}, config, searchQuery, triesbien.Page{Offset: searchOffset, Limit: searchLimit})
 	if err != nil {
 		glog.Errorf("query failed: %v", err)
 		os.Exit(1)
 	}
 	glog.Infof("result in %v", time.Since(started))
 
-	for _, r := range res {
-		fmt.Println(r)
+	glog.Infof("%v candidates, truncated: %v, next offset: %v", res.Candidates, res.Truncated, res.NextOffset)
+
+	for _, r := range res.Results {
+		fmt.Printf("%v\t%.3f\t%v\t%v\n", r.ID, r.Score, r.Document, strings.Join(r.Matched, ","))
 	}
 }
 
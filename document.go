@@ -0,0 +1,14 @@
+package triesbien
+
+// Document is a catalogue entry to be indexed.
+type Document struct {
+	// Key identifies the document in the catalogue, such as its SKU. It is
+	// only needed when ids are assigned by an IDMap.
+	Key string
+	// Text is parsed for lexemes and is what queries return.
+	Text string
+	// Score ranks the document against others sharing a prefix, such as its
+	// popularity or sales. When a prefix has more documents than fit in its
+	// bucket, those with the highest scores are kept.
+	Score float64
+}
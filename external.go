@@ -0,0 +1,289 @@
+package triesbien
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/QubitProducts/triesbien/trie"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// spillPair is a lexeme or suffix to be indexed under a document.
+type spillPair struct {
+	lexeme   string
+	id       uint64
+	terminal bool
+}
+
+// spillPairOverhead approximates the memory a buffered pair takes beyond
+// its lexeme.
+const spillPairOverhead = 48
+
+// scoreOverhead approximates the memory the trie takes to keep a document's
+// score.
+const scoreOverhead = 40
+
+func spillLess(a, b spillPair) bool {
+	if a.lexeme != b.lexeme {
+		return a.lexeme < b.lexeme
+	}
+	if a.id != b.id {
+		return a.id < b.id
+	}
+	return !a.terminal && b.terminal
+}
+
+// BuildTrieExternal builds the same trie as BuildTrie for catalogues too big
+// to index in memory. The (lexeme, id) pairs of the documents are buffered
+// up to about memoryBudget bytes at a time, sorted and spilled to run files
+// in dir. The runs are then merged and streamed into the trie, whose buckets
+// are truncated as they are completed, so the untruncated posting lists are
+// never held in memory at once. The trie keeps the scores of documents
+// throughout, as buckets are truncated by them, so they count against
+// memoryBudget too and leave less of it for pairs.
+func BuildTrieExternal(ctx context.Context, t *trie.Trie, config Config, dir string, memoryBudget int, catalogueChan <-chan Document) error {
+	if config.Postings != nil {
+		return errors.New("saturated posting lists can't be stored by an external build")
+	}
+
+	var runs []string
+	defer func() {
+		for _, r := range runs {
+			os.Remove(r)
+		}
+	}()
+
+	buf := []spillPair{}
+	size := 0
+	scoreSize := 0
+	var count uint64
+	for ; ; count++ {
+		var doc Document
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case doc, ok = <-catalogueChan:
+		}
+		if !ok {
+			break
+		}
+
+		id, err := documentID(config, doc, count)
+		if err != nil {
+			return err
+		}
+		if doc.Score != 0 {
+			t.SetScore(id, doc.Score)
+			scoreSize += scoreOverhead
+		}
+		ls, suffixes := indexTerms(config, doc.Text)
+		for _, l := range ls {
+			buf = append(buf, spillPair{lexeme: string(l), id: id, terminal: true})
+			size += len(string(l)) + spillPairOverhead
+		}
+		for _, s := range suffixes {
+			buf = append(buf, spillPair{lexeme: string(s), id: id})
+			size += len(string(s)) + spillPairOverhead
+		}
+
+		if size+scoreSize >= memoryBudget && len(buf) != 0 {
+			run, err := spillRun(dir, buf)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			buf = buf[:0]
+			size = 0
+		}
+	}
+
+	var next func() (spillPair, bool, error)
+	if len(runs) == 0 {
+		sort.Slice(buf, func(i, j int) bool { return spillLess(buf[i], buf[j]) })
+		next = func() (spillPair, bool, error) {
+			if len(buf) == 0 {
+				return spillPair{}, false, nil
+			}
+			p := buf[0]
+			buf = buf[1:]
+			return p, true, nil
+		}
+	} else {
+		if len(buf) != 0 {
+			run, err := spillRun(dir, buf)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		buf = nil
+		glog.V(1).Infof("merging %v runs", len(runs))
+		m, err := newRunMerger(runs)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+		next = m.Next
+	}
+
+	meta := config.TrieMetadata()
+	meta.DocumentCount = count
+	meta.BuiltAt = time.Now()
+	t.SetMetadata(meta)
+
+	b := t.NewStreamBuilder(config.MaxBucketLength)
+	for i := 0; ; i++ {
+		if i%4096 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p, ok, err := next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if err := b.Add([]rune(p.lexeme), p.id, p.terminal); err != nil {
+			return errors.Wrap(err, "could not build trie")
+		}
+	}
+	b.Finish()
+	t.Compress()
+	return nil
+}
+
+// spillRun sorts pairs and writes them to a new run file in dir, as a
+// uvarint lexeme length, the lexeme, a uvarint id and a terminal byte each.
+func spillRun(dir string, pairs []spillPair) (string, error) {
+	sort.Slice(pairs, func(i, j int) bool { return spillLess(pairs[i], pairs[j]) })
+
+	f, err := ioutil.TempFile(dir, "triesbien-run")
+	if err != nil {
+		return "", errors.Wrap(err, "could not create run file")
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	varint := make([]byte, binary.MaxVarintLen64)
+	for _, p := range pairs {
+		w.Write(varint[:binary.PutUvarint(varint, uint64(len(p.lexeme)))])
+		w.WriteString(p.lexeme)
+		w.Write(varint[:binary.PutUvarint(varint, p.id)])
+		if p.terminal {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "could not write run file")
+	}
+	glog.V(1).Infof("spilled %v pairs to %v", len(pairs), f.Name())
+	return f.Name(), nil
+}
+
+type runReader struct {
+	f    *os.File
+	r    *bufio.Reader
+	head spillPair
+}
+
+func (rr *runReader) advance() (bool, error) {
+	l, err := binary.ReadUvarint(rr.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "could not read run file")
+	}
+	lexeme := make([]byte, l)
+	if _, err := io.ReadFull(rr.r, lexeme); err != nil {
+		return false, errors.Wrap(err, "could not read run file")
+	}
+	id, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return false, errors.Wrap(err, "could not read run file")
+	}
+	terminal, err := rr.r.ReadByte()
+	if err != nil {
+		return false, errors.Wrap(err, "could not read run file")
+	}
+	rr.head = spillPair{lexeme: string(lexeme), id: id, terminal: terminal == 1}
+	return true, nil
+}
+
+// runMerger is a k-way merge of sorted run files.
+type runMerger []*runReader
+
+func newRunMerger(paths []string) (*runMerger, error) {
+	m := &runMerger{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			m.Close()
+			return nil, errors.Wrap(err, "could not open run file")
+		}
+		rr := &runReader{f: f, r: bufio.NewReader(f)}
+		ok, err := rr.advance()
+		if err != nil {
+			f.Close()
+			m.Close()
+			return nil, err
+		}
+		if !ok {
+			f.Close()
+			continue
+		}
+		*m = append(*m, rr)
+	}
+	heap.Init(m)
+	return m, nil
+}
+
+// Next returns the smallest pair left in any run.
+func (m *runMerger) Next() (spillPair, bool, error) {
+	if m.Len() == 0 {
+		return spillPair{}, false, nil
+	}
+	rr := (*m)[0]
+	p := rr.head
+	ok, err := rr.advance()
+	if err != nil {
+		return spillPair{}, false, err
+	}
+	if ok {
+		heap.Fix(m, 0)
+	} else {
+		rr.f.Close()
+		heap.Pop(m)
+	}
+	return p, true, nil
+}
+
+func (m *runMerger) Close() {
+	for _, rr := range *m {
+		rr.f.Close()
+	}
+	*m = nil
+}
+
+func (m runMerger) Len() int            { return len(m) }
+func (m runMerger) Less(i, j int) bool  { return spillLess(m[i].head, m[j].head) }
+func (m runMerger) Swap(i, j int)       { m[i], m[j] = m[j], m[i] }
+func (m *runMerger) Push(x interface{}) { *m = append(*m, x.(*runReader)) }
+func (m *runMerger) Pop() interface{} {
+	old := *m
+	rr := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return rr
+}
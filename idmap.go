@@ -0,0 +1,144 @@
+package triesbien
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// IDMap assigns internal document ids to catalogue keys, such as SKUs, and
+// is saved between builds so that a document keeps its id however the feed
+// it comes in is ordered or filtered. It is safe for concurrent use, so the
+// document store and the trie can be built from one map at once.
+type IDMap struct {
+	mu   sync.Mutex
+	ids  map[string]uint64
+	next uint64
+}
+
+// An id map file is idMapMagic followed by a uvarint id, uvarint key length
+// and key for every entry, in id order.
+var idMapMagic = []byte("TRIEIDS\x00")
+
+func NewIDMap() *IDMap {
+	return &IDMap{ids: map[string]uint64{}}
+}
+
+// LoadIDMap reads an id map saved by Save. A missing file gives an empty
+// map, as on the first build. A file giving an id or key twice is corrupt,
+// as reading it would give two documents one id.
+func LoadIDMap(path string) (*IDMap, error) {
+	m := NewIDMap()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read id map")
+	}
+	if !bytes.HasPrefix(data, idMapMagic) {
+		return nil, errors.New("not an id map")
+	}
+
+	seen := map[uint64]bool{}
+	r := bytes.NewReader(data[len(idMapMagic):])
+	for r.Len() != 0 {
+		id, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "id map is corrupt")
+		}
+		keyLen, err := binary.ReadUvarint(r)
+		if err != nil || keyLen > uint64(r.Len()) {
+			return nil, errors.New("id map is corrupt")
+		}
+		key := make([]byte, keyLen)
+		io.ReadFull(r, key)
+
+		if seen[id] {
+			return nil, errors.Errorf("id map is corrupt, id %v is given twice", id)
+		}
+		if _, ok := m.ids[string(key)]; ok {
+			return nil, errors.Errorf("id map is corrupt, key %q is given twice", key)
+		}
+		seen[id] = true
+		m.ids[string(key)] = id
+		if id >= m.next {
+			m.next = id + 1
+		}
+	}
+	return m, nil
+}
+
+// ID returns the id of key, assigning it the next unused one if it hasn't
+// been seen before.
+func (m *IDMap) ID(key string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.ids[key]
+	if !ok {
+		id = m.next
+		m.ids[key] = id
+		m.next++
+	}
+	return id
+}
+
+// Lookup returns the id of key, if it has one.
+func (m *IDMap) Lookup(key string) (uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.ids[key]
+	return id, ok
+}
+
+func (m *IDMap) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.ids)
+}
+
+// Save writes the map to path in id order, so that the same map always
+// gives the same file, replacing the previous file only once the new one is
+// complete.
+func (m *IDMap) Save(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return errors.Wrap(err, "could not create id map")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	keys := make([]string, 0, len(m.ids))
+	for key := range m.ids {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return m.ids[keys[i]] < m.ids[keys[j]] })
+
+	w := bufio.NewWriter(f)
+	w.Write(idMapMagic)
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, key := range keys {
+		id := m.ids[key]
+		w.Write(buf[:binary.PutUvarint(buf, id)])
+		w.Write(buf[:binary.PutUvarint(buf, uint64(len(key)))])
+		w.WriteString(key)
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "could not write id map")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "could not write id map")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "could not replace id map")
+}
@@ -0,0 +1,122 @@
+package triesbien
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/QubitProducts/triesbien/trie"
+)
+
+func TestIDMapSurvivesReordering(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "idmap")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ids")
+
+	docs := testDocuments(200)
+	for i := range docs {
+		docs[i].Key = fmt.Sprintf("key%d", i)
+	}
+	build := func(docs []Document) ([]byte, *MemoryStore) {
+		ids, err := LoadIDMap(path)
+		if err != nil {
+			t.Fatalf("could not load id map: %v", err)
+		}
+		config := testConfig()
+		config.IDs = ids
+		store := NewMemoryStore()
+		if err := WriteDocuments(context.Background(), store, config, documentChan(docs)); err != nil {
+			t.Fatalf("could not write documents: %v", err)
+		}
+		tr := trie.NewTrie()
+		if err := BuildTrieParallel(context.Background(), tr, config, 3, documentChan(docs)); err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := ids.Save(path); err != nil {
+			t.Fatalf("could not save id map: %v", err)
+		}
+		return marshalTrie(t, tr), store
+	}
+
+	expected, expectedStore := build(docs)
+
+	reversed := make([]Document, 0, len(docs))
+	for i := len(docs) - 1; i >= 0; i-- {
+		reversed = append(reversed, docs[i])
+	}
+	got, gotStore := build(reversed)
+	if !bytes.Equal(got, expected) {
+		t.Errorf("trie built from the reordered feed differs")
+	}
+	for id := uint64(0); id < uint64(len(docs)); id++ {
+		e, _ := expectedStore.Get(id)
+		g, _ := gotStore.Get(id)
+		if g != e {
+			t.Errorf("document %v moved\nGot: %v\nExpected: %v", id, g, e)
+		}
+	}
+
+	ids, err := LoadIDMap(path)
+	if err != nil {
+		t.Fatalf("could not load id map: %v", err)
+	}
+	if ids.Len() != len(docs) || ids.ID("new") != uint64(len(docs)) {
+		t.Errorf("expected %v ids with the next one free, got %v", len(docs), ids.Len())
+	}
+}
+
+func TestIDMapFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "idmap")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ids")
+
+	m := NewIDMap()
+	for _, key := range []string{"c", "a", "b"} {
+		m.ID(key)
+	}
+	if err := m.Save(path); err != nil {
+		t.Fatalf("could not save id map: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read id map: %v", err)
+	}
+	expected := append(append([]byte{}, idMapMagic...), 0, 1, 'c', 1, 1, 'a', 2, 1, 'b')
+	if !bytes.Equal(data, expected) {
+		t.Errorf("expected entries in id order\nGot: %q\nExpected: %q", data, expected)
+	}
+
+	cases := []struct {
+		name    string
+		entries []byte
+		err     string
+	}{
+		{name: "duplicate id", entries: []byte{0, 1, 'a', 0, 1, 'b'}, err: "id 0 is given twice"},
+		{name: "duplicate key", entries: []byte{0, 1, 'a', 1, 1, 'a'}, err: `key "a" is given twice`},
+		{name: "truncated", entries: []byte{0, 2, 'a'}, err: "corrupt"},
+	}
+	for i, c := range cases {
+		path := filepath.Join(dir, fmt.Sprintf("corrupt%d", i))
+		if err := ioutil.WriteFile(path, append(append([]byte{}, idMapMagic...), c.entries...), 0644); err != nil {
+			t.Fatalf("could not write id map: %v", err)
+		}
+		if _, err := LoadIDMap(path); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v: unexpected error\nGot: %v\nExpected: %v", c.name, err, c.err)
+		}
+	}
+}
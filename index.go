@@ -0,0 +1,72 @@
+package triesbien
+
+import (
+	"math"
+
+	"github.com/QubitProducts/triesbien/trie"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Index finds the ids of the documents stored under a prefix. *trie.Trie,
+// *trie.Mapped, *trie.DAWG and *MemoryIndex all implement it.
+type Index interface {
+	Lookup(value []rune) []uint64
+}
+
+// IndexWriter is an Index that BuildTrie can add documents to, in ascending
+// order of id. *trie.Trie and *MemoryIndex implement it.
+type IndexWriter interface {
+	Index
+	// Append adds entry under a whole lexeme, and AppendSuffix under a
+	// suffix of one.
+	Append(value []rune, entry uint64)
+	AppendSuffix(value []rune, entry uint64)
+	SetScore(entry uint64, score float64)
+}
+
+// FuzzyLookuper is implemented by indexes that can find prefixes within an
+// edit distance of a value, such as *trie.Trie.
+type FuzzyLookuper interface {
+	FuzzyLookup(value []rune, maxEdits int) ([]uint64, []trie.FuzzyMatch)
+}
+
+// DocumentStore holds the text of every indexed document by id. LevelDBStore
+// and *MemoryStore implement it.
+type DocumentStore interface {
+	Get(id uint64) (string, error)
+	Put(id uint64, text string) error
+	Delete(id uint64) error
+}
+
+// LevelDBStore keeps documents in LevelDB, keyed by their little endian
+// ids. Databases written when ids were 32 bits wide are still read.
+type LevelDBStore struct {
+	DB *leveldb.DB
+}
+
+func (s LevelDBStore) Get(id uint64) (string, error) {
+	v, err := s.DB.Get(toBS(id), nil)
+	if err == leveldb.ErrNotFound && id <= math.MaxUint32 {
+		v, err = s.DB.Get(toBS32(uint32(id)), nil)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "could not read from leveldb")
+	}
+	return string(v), nil
+}
+
+func (s LevelDBStore) Put(id uint64, text string) error {
+	err := s.DB.Put(toBS(id), []byte(text), nil)
+	return errors.Wrap(err, "could not write to leveldb")
+}
+
+func (s LevelDBStore) Delete(id uint64) error {
+	b := &leveldb.Batch{}
+	b.Delete(toBS(id))
+	if id <= math.MaxUint32 {
+		b.Delete(toBS32(uint32(id)))
+	}
+	err := s.DB.Write(b, nil)
+	return errors.Wrap(err, "could not delete from leveldb")
+}
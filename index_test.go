@@ -0,0 +1,44 @@
+package triesbien
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestLevelDBStore(t *testing.T) {
+	t.Parallel()
+
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("could not open leveldb: %v", err)
+	}
+	defer db.Close()
+	store := LevelDBStore{DB: db}
+
+	// a document written when ids were uint32s
+	if err := db.Put(toBS32(7), []byte("legacy"), nil); err != nil {
+		t.Fatalf("could not write document: %v", err)
+	}
+	if err := store.Put(1<<40, "wide"); err != nil {
+		t.Fatalf("could not write document: %v", err)
+	}
+
+	for id, expected := range map[uint64]string{7: "legacy", 1 << 40: "wide"} {
+		got, err := store.Get(id)
+		if err != nil {
+			t.Fatalf("could not read document %v: %v", id, err)
+		}
+		if got != expected {
+			t.Errorf("unexpected document %v\nGot: %v\nExpected: %v", id, got, expected)
+		}
+	}
+
+	if err := store.Delete(7); err != nil {
+		t.Fatalf("could not delete document: %v", err)
+	}
+	if _, err := store.Get(7); err == nil {
+		t.Errorf("expected deleted document to be gone")
+	}
+}
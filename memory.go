@@ -0,0 +1,128 @@
+package triesbien
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryIndex is an Index holding the full posting list of every lexeme in
+// memory, so lookups are never truncated. It suits small catalogues and
+// tests.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	lexemes  []string
+	postings map[string][]uint64
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{postings: map[string][]uint64{}}
+}
+
+func (m *MemoryIndex) Append(value []rune, entry uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lexeme := string(value)
+	entries, ok := m.postings[lexeme]
+	if !ok {
+		ix := sort.SearchStrings(m.lexemes, lexeme)
+		m.lexemes = append(m.lexemes, "")
+		copy(m.lexemes[ix+1:], m.lexemes[ix:])
+		m.lexemes[ix] = lexeme
+	}
+	ix := sort.Search(len(entries), func(i int) bool {
+		return entries[i] >= entry
+	})
+	if ix < len(entries) && entries[ix] == entry {
+		return
+	}
+	entries = append(entries, 0)
+	copy(entries[ix+1:], entries[ix:])
+	entries[ix] = entry
+	m.postings[lexeme] = entries
+}
+
+// AppendSuffix is the same as Append, as a MemoryIndex has no completions
+// to keep suffixes out of.
+func (m *MemoryIndex) AppendSuffix(value []rune, entry uint64) {
+	m.Append(value, entry)
+}
+
+// SetScore does nothing, as a MemoryIndex never has to choose which entries
+// to keep.
+func (m *MemoryIndex) SetScore(entry uint64, score float64) {}
+
+// Lookup returns the union of the posting lists of every lexeme starting
+// with value.
+func (m *MemoryIndex) Lookup(value []rune) []uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prefix := string(value)
+	res := []uint64{}
+	for ix := sort.SearchStrings(m.lexemes, prefix); ix < len(m.lexemes); ix++ {
+		if !strings.HasPrefix(m.lexemes[ix], prefix) {
+			break
+		}
+		res = unionEntries(res, m.postings[m.lexemes[ix]])
+	}
+	return res
+}
+
+func unionEntries(a, b []uint64) []uint64 {
+	res := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			res = append(res, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			res = append(res, a[i])
+			i++
+		default:
+			res = append(res, b[j])
+			j++
+		}
+	}
+	res = append(res, a[i:]...)
+	return append(res, b[j:]...)
+}
+
+// MemoryStore is a DocumentStore held in a map.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	docs map[uint64]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{docs: map[uint64]string{}}
+}
+
+func (s *MemoryStore) Get(id uint64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	text, ok := s.docs[id]
+	if !ok {
+		return "", errors.Errorf("no document %v", id)
+	}
+	return text, nil
+}
+
+func (s *MemoryStore) Put(id uint64, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[id] = text
+	return nil
+}
+
+func (s *MemoryStore) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.docs, id)
+	return nil
+}
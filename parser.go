@@ -1,9 +1,49 @@
 package triesbien
 
+import "github.com/QubitProducts/triesbien/trie"
+
 type Config struct {
-	Parser          Parser
+	Parser Parser
+	// ParserName identifies Parser in trie file headers, so that a trie
+	// isn't loaded with a different parser to the one it was built with.
+	ParserName      string
 	MaxLexemeLength int
 	MaxBucketLength int
+	// FuzzyMaxEdits, if non zero, is the edit distance within which query
+	// parts are matched when they aren't found exactly.
+	FuzzyMaxEdits int
+	// IndexSuffixes also indexes every suffix of each lexeme at least
+	// MinSuffixLength runes long, so that query parts match within lexemes
+	// as well as at their start.
+	IndexSuffixes   bool
+	MinSuffixLength int
+	// Postings, if set, is filled with the full posting lists of saturated
+	// prefixes when building, and used to answer queries on them exactly.
+	Postings PostingStore
+	// IDs, if set, gives documents ids by their keys, rather than by their
+	// position in the feed they are built from.
+	IDs *IDMap
+	// Ranking orders the results of queries.
+	Ranking Ranking
 }
 
 type Parser func(string) []string
+
+// TrieMetadata is the metadata a trie built with config is expected to have.
+// The minimum suffix length is the one suffixes are indexed with, which is 0
+// if they aren't.
+func (c Config) TrieMetadata() trie.Metadata {
+	meta := trie.Metadata{
+		MaxLexemeLength: c.MaxLexemeLength,
+		MaxBucketLength: c.MaxBucketLength,
+		Parser:          c.ParserName,
+		IndexSuffixes:   c.IndexSuffixes,
+	}
+	if c.IndexSuffixes {
+		meta.MinSuffixLength = c.MinSuffixLength
+		if meta.MinSuffixLength < 1 {
+			meta.MinSuffixLength = 1
+		}
+	}
+	return meta
+}
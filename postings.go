@@ -0,0 +1,141 @@
+package triesbien
+
+import (
+	"context"
+	"sort"
+
+	"github.com/QubitProducts/triesbien/trie"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// PostingStore holds the full posting lists of prefixes whose buckets in the
+// trie are saturated, so that queries on them needn't be lossy.
+type PostingStore interface {
+	PutPostings(prefix string, entries []uint64) error
+	// Postings returns the entries stored for prefix, or false if there
+	// are none.
+	Postings(prefix string) (*trie.PostingIterator, bool, error)
+}
+
+var postingsKeyPrefix = []byte("postings:")
+
+// LevelDBPostings stores posting lists packed by trie.EncodePostings. Its
+// keys can't clash with document ids, so it may share their database.
+type LevelDBPostings struct {
+	DB *leveldb.DB
+}
+
+func (p LevelDBPostings) PutPostings(prefix string, entries []uint64) error {
+	err := p.DB.Put(postingsKey(prefix), trie.EncodePostings(entries), nil)
+	return errors.Wrap(err, "could not write posting list")
+}
+
+func (p LevelDBPostings) Postings(prefix string) (*trie.PostingIterator, bool, error) {
+	data, err := p.DB.Get(postingsKey(prefix), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not read posting list")
+	}
+	return trie.NewPostingIterator(data), true, nil
+}
+
+func postingsKey(prefix string) []byte {
+	return append(append([]byte{}, postingsKeyPrefix...), prefix...)
+}
+
+// updatePostings adds id to, or removes it from, the stored posting lists
+// of every prefix of lexemes. Prefixes that weren't saturated have no list
+// and are left alone.
+func updatePostings(store PostingStore, lexemes [][]rune, id uint64, add bool) error {
+	seen := map[string]bool{}
+	for _, lexeme := range lexemes {
+		for i := 1; i <= len(lexeme); i++ {
+			prefix := string(lexeme[:i])
+			if seen[prefix] {
+				continue
+			}
+			seen[prefix] = true
+
+			it, ok, err := store.Postings(prefix)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			// an update mustn't stop halfway through a list
+			entries, err := drainPostings(context.Background(), it)
+			if err != nil {
+				return err
+			}
+			ix := sort.Search(len(entries), func(i int) bool {
+				return entries[i] >= id
+			})
+			found := ix < len(entries) && entries[ix] == id
+			switch {
+			case add && !found:
+				entries = append(entries, 0)
+				copy(entries[ix+1:], entries[ix:])
+				entries[ix] = id
+			case !add && found:
+				entries = append(entries[:ix], entries[ix+1:]...)
+			default:
+				continue
+			}
+			if err := store.PutPostings(prefix, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postingsCheckInterval is how many entries of a posting list are decoded
+// between checks that the query they are for hasn't ended.
+const postingsCheckInterval = 4096
+
+func drainPostings(ctx context.Context, it *trie.PostingIterator) ([]uint64, error) {
+	res := []uint64{}
+	for n := 1; ; n++ {
+		if n%postingsCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		e, ok := it.Next()
+		if !ok {
+			return res, nil
+		}
+		res = append(res, e)
+	}
+}
+
+// intersectPostings keeps the entries of a that also come out of it,
+// decoding no more of it than it needs to.
+func intersectPostings(ctx context.Context, a []uint64, it *trie.PostingIterator) ([]uint64, error) {
+	res := []uint64{}
+	if len(a) == 0 {
+		return res, nil
+	}
+	i := 0
+	for n := 1; ; n++ {
+		if n%postingsCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		e, ok := it.Next()
+		if !ok {
+			return res, nil
+		}
+		for i < len(a) && a[i] < e {
+			i++
+		}
+		if i == len(a) {
+			return res, nil
+		}
+		if a[i] == e {
+			res = append(res, e)
+			i++
+		}
+	}
+}
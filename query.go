@@ -1,101 +1,354 @@
 package triesbien
 
 import (
+	"context"
+	"sort"
 	"strings"
 
 	"github.com/QubitProducts/triesbien/trie"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
-	"github.com/syndtr/goleveldb/leveldb"
 )
 
-func Query(t *trie.Trie, db *leveldb.DB, config Config, query string) ([]string, error) {
-	parts := config.Parser(query)
+// Result is a document matching a query, along with how well it matched.
+type Result struct {
+	ID       uint64  `json:"id"`
+	Document string  `json:"document"`
+	Score    float64 `json:"score"`
+	// Matched holds the lexemes of the document that query parts matched.
+	// Parts that were only fuzzy matched have none.
+	Matched []string `json:"matched"`
+}
+
+// QueryResponse holds the results of a query.
+type QueryResponse struct {
+	Results []Result `json:"results"`
+	// Candidates is how many documents the index returned for the query,
+	// before any were filtered out for not matching it in full.
+	Candidates int `json:"candidates"`
+	// Truncated is set when a query part's bucket was saturated and had no
+	// full posting list to stand in for it, or when there were more
+	// candidates than the ranking reads, so some matches may be missing.
+	Truncated bool `json:"truncated"`
+	// Partial is set when the query's context ended while documents were
+	// being read, so only those read by then were ranked and paged.
+	Partial bool `json:"partial,omitempty"`
+	// NextOffset is the offset of the next page of results, or zero if
+	// this is the last. Partial responses have none, as the results of a
+	// query that read further would be ranked differently.
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+// Page selects which of the results of a query to return. A zero Limit
+// returns every result from Offset on.
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+// Query returns a page of the documents matching query, best first as
+// ranked by config.Ranking. Queries that can't be parsed give a ParseError.
+// Candidates have to be read to rank them, up to the ranking's
+// MaxCandidates, but if config.Ranking doesn't rank, they are read only
+// until the page is full.
+//
+// If ctx ends while the index is being searched, including while the full
+// posting lists of saturated prefixes are streamed, no documents have been
+// read and its error is returned. If it ends while documents are being
+// read, the results read so far are returned, marked as partial.
+func Query(ctx context.Context, t Index, docs DocumentStore, config Config, query string, page Page) (*QueryResponse, error) {
+	root, err := parseQuery(config, query)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return &QueryResponse{Results: []Result{}}, nil
+	}
+	parts := positiveParts(root)
+
+	e := &evaluator{ctx: ctx, t: t, config: config}
+	combined, err := e.eval(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	combinedResultIXs := combined.ids
+
+	// unranked results are already in order, so only as many as the page
+	// needs, and one more to tell whether there's another, are fetched
+	ranked := config.Ranking.ranks()
+	wanted := -1
+	if !ranked && page.Limit > 0 {
+		wanted = page.Offset + page.Limit + 1
+	}
+	capped := false
+	if max := config.Ranking.MaxCandidates; ranked && max > 0 && len(combinedResultIXs) > max {
+		glog.V(1).Infof("ranking %v of %v candidates", max, len(combinedResultIXs))
+		combinedResultIXs = combinedResultIXs[:max]
+		capped = true
+	}
+	filteredResults := make([]Result, 0, len(combinedResultIXs))
+	partial := false
+	for _, ix := range combinedResultIXs {
+		if len(filteredResults) == wanted {
+			break
+		}
+		if ctx.Err() != nil {
+			glog.V(1).Infof("query ended after reading %v candidates", len(filteredResults))
+			partial = true
+			break
+		}
+		v, err := docs.Get(ix)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read document")
+		}
+		glog.V(2).Infof("candidate %v", v)
+
+		// candidates from saturated buckets, phrases and exclusions are
+		// only known to match once the document is read
+		if !combined.exact && !matchNode(config, root, config.Parser(v)) {
+			glog.V(2).Infof("%v doesn't match, filtering out", v)
+			continue
+		}
+		filteredResults = append(filteredResults, Result{ID: ix, Document: v})
+	}
 
-	results := make([][]uint32, len(parts))
-	requireManualSearch := make([]string, 0)
-	intersectionalResults := make([][]uint32, 0, len(parts))
-	for i, part := range parts {
-		if len(part) > config.MaxLexemeLength {
-			requireManualSearch = append(requireManualSearch, part)
-			part = part[0:config.MaxLexemeLength]
+	boosts := config.Ranking.Boosts
+	if s, ok := t.(Scorer); ok && boosts == nil {
+		boosts = s.Score
+	}
+	score := func(res []Result) {
+		for i, r := range res {
+			res[i].Score, res[i].Matched = config.Ranking.score(config, parts, r.ID, r.Document, boosts)
+		}
+	}
+	if ranked {
+		score(filteredResults)
+		sort.SliceStable(filteredResults, func(i, j int) bool {
+			return filteredResults[i].Score > filteredResults[j].Score
+		})
+	}
+
+	resp := &QueryResponse{
+		Results:    filteredResults,
+		Candidates: len(combined.ids),
+		Truncated:  combined.partial || capped,
+		Partial:    partial,
+	}
+	if page.Offset > len(resp.Results) {
+		page.Offset = len(resp.Results)
+	}
+	resp.Results = resp.Results[page.Offset:]
+	if page.Limit > 0 && len(resp.Results) > page.Limit {
+		resp.Results = resp.Results[:page.Limit]
+		if !partial {
+			resp.NextOffset = page.Offset + page.Limit
+		}
+	}
+	if !ranked {
+		score(resp.Results)
+	}
+	return resp, nil
+}
+
+// candidates are the documents an index gives for a query node. They hold
+// every match, along with documents that only might match until they are
+// read, unless partial is set, when a saturated bucket may have lost some.
+type candidates struct {
+	ids     []uint64
+	partial bool
+	// exact is set if every document does match.
+	exact bool
+	// part is the query part looked up, if the candidates are those of a
+	// single part, so that its full posting list can be found.
+	part string
+}
+
+type evaluator struct {
+	ctx    context.Context
+	t      Index
+	config Config
+	// excluded is set while evaluating what a query excludes, whose parts
+	// aren't fuzzy matched, as a near miss would exclude documents that
+	// don't contain what was written.
+	excluded bool
+}
+
+func (e *evaluator) eval(n queryNode) (candidates, error) {
+	switch n := n.(type) {
+	case *partNode:
+		return e.lookup(n)
+	case *phraseNode:
+		nodes := make([]queryNode, len(n.parts))
+		for i, p := range n.parts {
+			nodes[i] = p
 		}
-		glog.V(2).Infof("Looking up %v\n", part)
-		results[i] = t.Lookup([]rune(part))
-		glog.V(2).Infof("%v results", len(results[i]))
-		if glog.V(4) {
-			glog.Infof("%v", results[i])
+		c, err := e.and(nodes)
+		c.exact = false
+		return c, err
+	case *andNode:
+		return e.and(n.nodes)
+	case *orNode:
+		res := candidates{ids: []uint64{}, exact: true}
+		for _, node := range n.nodes {
+			c, err := e.eval(node)
+			if err != nil {
+				return candidates{}, err
+			}
+			res.ids = arrUnion(res.ids, c.ids)
+			res.partial = res.partial || c.partial
+			res.exact = res.exact && c.exact
 		}
+		return res, nil
+	}
+	return candidates{}, errors.Errorf("unexpected query node %T", n)
+}
+
+func (e *evaluator) lookup(n *partNode) (candidates, error) {
+	if err := e.ctx.Err(); err != nil {
+		return candidates{}, err
+	}
+	part := n.part
+	tooLong := len(part) > e.config.MaxLexemeLength
+	if tooLong {
+		part = part[0:e.config.MaxLexemeLength]
+	}
+	glog.V(2).Infof("Looking up %v\n", part)
+	res := e.t.Lookup([]rune(part))
+	glog.V(2).Infof("%v results", len(res))
+	if glog.V(4) {
+		glog.Infof("%v", res)
+	}
 
-		if len(results[i]) >= config.MaxBucketLength {
-			if len(requireManualSearch) == 0 ||
-				requireManualSearch[len(requireManualSearch)-1] != part {
+	if len(res) == 0 && e.config.FuzzyMaxEdits > 0 && !e.excluded {
+		if f, ok := e.t.(FuzzyLookuper); ok {
+			var matches []trie.FuzzyMatch
+			res, matches = f.FuzzyLookup([]rune(part), e.config.FuzzyMaxEdits)
+			glog.V(2).Infof("fuzzy matched %v to %v, %v results", part, matches, len(res))
 
-				requireManualSearch = append(requireManualSearch, part)
+			// fuzzy matches won't pass a manual prefix search, so
+			// whatever they return has to be taken as is
+			if len(res) != 0 {
+				n.fuzzy = true
 			}
+			return candidates{ids: res, exact: true}, nil
+		}
+	}
+
+	if len(res) >= e.config.MaxBucketLength {
+		return candidates{ids: res, partial: true, exact: !tooLong, part: part}, nil
+	}
+	return candidates{ids: res, exact: !tooLong}, nil
+}
+
+// and intersects the candidates of nodes, less those of any they exclude.
+// The result is exact if every list it was made from was, no saturated
+// bucket had to be left out of the intersection and every exclusion was
+// taken away in full.
+func (e *evaluator) and(nodes []queryNode) (candidates, error) {
+	var include, exclude []candidates
+	for _, n := range nodes {
+		ev := e
+		not, ok := n.(*notNode)
+		if ok {
+			n = not.node
+			excluding := *e
+			excluding.excluded = true
+			ev = &excluding
+		}
+		c, err := ev.eval(n)
+		if err != nil {
+			return candidates{}, err
+		}
+		if ok {
+			exclude = append(exclude, c)
 		} else {
-			intersectionalResults = append(intersectionalResults, results[i])
+			include = append(include, c)
 		}
 	}
-	if glog.V(2) {
-		glog.Infof("query parts requiring manual search: %v", strings.Join(requireManualSearch, ", "))
+
+	// the full lists of saturated parts are only worth reading when there
+	// is something to intersect them with, otherwise they'd return most of
+	// the catalogue
+	complete := [][]uint64{}
+	var streams []*trie.PostingIterator
+	exact := true
+	for _, c := range include {
+		exact = exact && c.exact
+		if !c.partial {
+			complete = append(complete, c.ids)
+			continue
+		}
+		if e.config.Postings == nil || c.part == "" || len(include) == 1 {
+			exact = false
+			continue
+		}
+		it, ok, err := e.config.Postings.Postings(c.part)
+		if err != nil {
+			return candidates{}, err
+		}
+		if ok {
+			streams = append(streams, it)
+		} else {
+			exact = false
+		}
 	}
 
-	combinedResultIXs := []uint32{}
-	if len(intersectionalResults) != 0 {
+	res := candidates{exact: exact}
+	switch {
+	case len(complete) != 0:
 		glog.V(1).Infof("intersecting results")
-		combinedResultIXs = resultIntersection(intersectionalResults)
-	} else {
+		res.ids = resultIntersection(complete)
+	case len(streams) != 0:
+		glog.V(1).Infof("intersecting full posting lists")
+		ids, err := drainPostings(e.ctx, streams[0])
+		if err != nil {
+			return candidates{}, err
+		}
+		res.ids = ids
+		streams = streams[1:]
+	default:
 		glog.V(1).Infof("unioning results (nothing better to do)")
-		combinedResultIXs = resultUnion(results)
+		lists := make([][]uint64, len(include))
+		for i, c := range include {
+			lists[i] = c.ids
+		}
+		res.ids = resultUnion(lists)
+		res.partial = true
+		res.exact = len(include) == 1 && include[0].exact
+		streams = nil
 	}
-	combinedResults := make([]string, 0, len(combinedResultIXs))
-	for _, ix := range combinedResultIXs {
-		w, err := db.Get(toBS(ix), nil)
+	for _, it := range streams {
+		ids, err := intersectPostings(e.ctx, res.ids, it)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not read index")
-		}
-		combinedResults = append(combinedResults, string(w))
-	}
-
-	if glog.V(2) {
-		glog.Infof("combined results")
-		for _, s := range combinedResults {
-			glog.Info(s)
-		}
-	}
-
-	manuallyFilteredResults := combinedResults
-	if len(requireManualSearch) != 0 {
-		manuallyFilteredResults = make([]string, 0, len(combinedResults))
-		for _, v := range combinedResults {
-			filtered := false
-			valParts := config.Parser(v)
-			for _, part := range requireManualSearch {
-				found := false
-				for _, valPart := range valParts {
-					if strings.HasPrefix(valPart, part) {
-						glog.V(2).Infof("found %v in %v", part, v)
-						found = true
-						break
-					}
-				}
-				if !found {
-					glog.V(2).Infof("didn't find %v in %v, filtering out", part, v)
-					filtered = true
-					break
-				}
-			}
-			if !filtered {
-				manuallyFilteredResults = append(manuallyFilteredResults, v)
-			}
+			return candidates{}, err
+		}
+		res.ids = ids
+	}
+
+	// candidates that might not match can't be excluded, but they will be
+	// once the documents are read
+	for _, c := range exclude {
+		if c.exact {
+			res.ids = arrDifference(res.ids, c.ids)
 		}
+		res.exact = res.exact && c.exact && !c.partial
 	}
+	return res, nil
+}
 
-	return manuallyFilteredResults, nil
+// matchPart reports whether a query part matches a lexeme of a document,
+// either at its start or, if suffixes are indexed, anywhere within it.
+func matchPart(config Config, lexeme, part string) bool {
+	if config.IndexSuffixes {
+		return strings.Contains(lexeme, part)
+	}
+	return strings.HasPrefix(lexeme, part)
 }
 
-func resultIntersection(inp [][]uint32) []uint32 {
+func resultIntersection(inp [][]uint64) []uint64 {
 	if len(inp) == 0 {
 		return nil
 	}
@@ -106,10 +359,10 @@ func resultIntersection(inp [][]uint32) []uint32 {
 	return seen
 }
 
-func arrIntersection(a, b []uint32) []uint32 {
+func arrIntersection(a, b []uint64) []uint64 {
 	i := 0
 	j := 0
-	res := []uint32{}
+	res := []uint64{}
 	for {
 		if i >= len(a) || j >= len(b) {
 			return res
@@ -125,7 +378,7 @@ func arrIntersection(a, b []uint32) []uint32 {
 	}
 }
 
-func resultUnion(inp [][]uint32) []uint32 {
+func resultUnion(inp [][]uint64) []uint64 {
 	if len(inp) == 0 {
 		return nil
 	}
@@ -136,13 +389,14 @@ func resultUnion(inp [][]uint32) []uint32 {
 	return seen
 }
 
-func arrUnion(a, b []uint32) []uint32 {
+func arrUnion(a, b []uint64) []uint64 {
 	i := 0
 	j := 0
-	res := []uint32{}
+	res := []uint64{}
 	for {
 		if i >= len(a) || j >= len(b) {
-			return res
+			res = append(res, a[i:]...)
+			return append(res, b[j:]...)
 		} else if a[i] == b[j] {
 			res = append(res, a[i])
 			i++
@@ -156,3 +410,25 @@ func arrUnion(a, b []uint32) []uint32 {
 		}
 	}
 }
+
+// arrDifference returns the entries of a that aren't in b.
+func arrDifference(a, b []uint64) []uint64 {
+	i := 0
+	j := 0
+	res := []uint64{}
+	for {
+		if i >= len(a) {
+			return res
+		} else if j >= len(b) {
+			return append(res, a[i:]...)
+		} else if a[i] == b[j] {
+			i++
+			j++
+		} else if a[i] < b[j] {
+			res = append(res, a[i])
+			i++
+		} else {
+			j++
+		}
+	}
+}
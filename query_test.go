@@ -1,21 +1,30 @@
 package triesbien
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/QubitProducts/triesbien/trie"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
 func TestArrIntersection(t *testing.T) {
 	t.Parallel()
 
 	cases := []struct {
-		a, b     []uint32
-		expected []uint32
+		a, b     []uint64
+		expected []uint64
 	}{
 		{
-			a:        []uint32{1, 2, 3, 4},
-			b:        []uint32{2, 3},
-			expected: []uint32{2, 3},
+			a:        []uint64{1, 2, 3, 4},
+			b:        []uint64{2, 3},
+			expected: []uint64{2, 3},
 		},
 	}
 
@@ -31,3 +40,551 @@ func TestArrIntersection(t *testing.T) {
 		})
 	}
 }
+
+func TestArrUnionDifference(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		a, b       []uint64
+		union      []uint64
+		difference []uint64
+	}{
+		{
+			a:          []uint64{1, 2, 3, 4},
+			b:          []uint64{2, 3},
+			union:      []uint64{1, 2, 3, 4},
+			difference: []uint64{1, 4},
+		},
+		{
+			a:          []uint64{1, 5},
+			b:          []uint64{2, 3, 7, 9},
+			union:      []uint64{1, 2, 3, 5, 7, 9},
+			difference: []uint64{1, 5},
+		},
+		{
+			a:          []uint64{},
+			b:          []uint64{2},
+			union:      []uint64{2},
+			difference: []uint64{},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			if got := arrUnion(c.a, c.b); !reflect.DeepEqual(got, c.union) {
+				t.Errorf("unexpected union\nGot: %v\nExpected: %v", got, c.union)
+			}
+			if got := arrDifference(c.a, c.b); !reflect.DeepEqual(got, c.difference) {
+				t.Errorf("unexpected difference\nGot: %v\nExpected: %v", got, c.difference)
+			}
+		})
+	}
+}
+
+// catalogue is a handful of shoes and shorts, sharing enough words for
+// queries over it to need more than one bucket.
+var catalogue = []Document{
+	{Text: "nike running shoes"},
+	{Text: "adidas running shoes kids"},
+	{Text: "adidas shoes for running"},
+	{Text: "puma running shoes"},
+	{Text: "nike running shorts"},
+	{Text: "nike kids running shoes"},
+}
+
+func TestQueryBoolean(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig()
+	config.MaxLexemeLength = 10
+	// no bucket is saturated, so nothing is lost
+	config.MaxBucketLength = len(catalogue) + 1
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{query: `"running shoes" -kids nike|adidas`, expected: []string{"nike running shoes"}},
+		{query: `"running shoes" nike|adidas`, expected: []string{"nike running shoes", "adidas running shoes kids", "nike kids running shoes"}},
+		{query: `shoes -(kids|nike)`, expected: []string{"adidas shoes for running", "puma running shoes"}},
+		{query: `nike (shoes|shorts) -kids`, expected: []string{"nike running shoes", "nike running shorts"}},
+		{query: `"shoes running"`, expected: []string{}},
+		{query: `sho -"running shoes"`, expected: []string{"adidas shoes for running", "nike running shorts"}},
+		{query: `--puma`, expected: []string{"puma running shoes"}},
+	}
+
+	for _, index := range []IndexWriter{NewMemoryIndex(), trie.NewTrie()} {
+		index := index
+		store := buildTestIndex(t, config, catalogue, index)
+		for _, c := range cases {
+			c := c
+			t.Run(fmt.Sprintf("%T %s", index, c.query), func(t *testing.T) {
+				t.Parallel()
+
+				res, err := Query(context.Background(), index, store, config, c.query, Page{})
+				if err != nil {
+					t.Fatalf("query failed: %v", err)
+				}
+				if got := resultDocuments(res.Results); !reflect.DeepEqual(got, c.expected) || res.Truncated {
+					t.Errorf("unexpected result\nGot: %v, truncated: %v\nExpected: %v", got, res.Truncated, c.expected)
+				}
+			})
+		}
+	}
+}
+
+func TestQueryTruncated(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig()
+	config.MaxLexemeLength = 10
+	config.MaxBucketLength = 3
+	index := trie.NewTrie()
+	store := buildTestIndex(t, config, catalogue, index)
+
+	// saturated buckets keep their first three documents, losing the
+	// others, but whatever they return still has to match in full
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "running", expected: []string{"nike running shoes", "adidas running shoes kids", "adidas shoes for running"}},
+		{query: "shoes -kids", expected: []string{"nike running shoes", "adidas shoes for running"}},
+		{query: `"running shoes" -kids nike|adidas`, expected: []string{"nike running shoes"}},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.query, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := Query(context.Background(), index, store, config, c.query, Page{})
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if got := resultDocuments(res.Results); !reflect.DeepEqual(got, c.expected) || !res.Truncated {
+				t.Errorf("unexpected result\nGot: %v, truncated: %v\nExpected: %v, truncated", got, res.Truncated, c.expected)
+			}
+		})
+	}
+}
+
+func TestEvalExact(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig()
+	config.MaxLexemeLength = 10
+	// the bucket of running is saturated
+	config.MaxBucketLength = len(catalogue)
+	index := trie.NewTrie()
+	buildTestIndex(t, config, catalogue, index)
+
+	// only candidates that are known to match can skip being checked
+	// against the documents
+	cases := []struct {
+		query    string
+		expected bool
+	}{
+		{query: "nike", expected: true},
+		{query: "nike shoes", expected: true},
+		{query: "nike|puma shoes -kids", expected: true},
+		{query: `"running shoes"`, expected: false},
+		{query: `shoes -"shoes kids"`, expected: false},
+		{query: "nike running", expected: false},
+		{query: "shoes -running", expected: false},
+		{query: "nike runningshoes", expected: false},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.query, func(t *testing.T) {
+			t.Parallel()
+
+			root, err := parseQuery(config, c.query)
+			if err != nil {
+				t.Fatalf("could not parse query: %v", err)
+			}
+			e := &evaluator{ctx: context.Background(), t: index, config: config}
+			res, err := e.eval(root)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if res.exact != c.expected {
+				t.Errorf("expected candidates to be exact: %v, got %v", c.expected, res.exact)
+			}
+		})
+	}
+}
+
+func TestQueryFuzzyExclusion(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig()
+	config.MaxLexemeLength = 10
+	config.FuzzyMaxEdits = 1
+	index := trie.NewTrie()
+	store := buildTestIndex(t, config, catalogue, index)
+
+	shoes := []string{"nike running shoes", "adidas running shoes kids", "adidas shoes for running", "puma running shoes", "nike kids running shoes"}
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		// neither exclusion is in any document, though pumo is an edit
+		// away from puma
+		{query: "shoes -zzzz", expected: shoes},
+		{query: "shoes -pumo", expected: shoes},
+		{query: "shoes -puma", expected: []string{"nike running shoes", "adidas running shoes kids", "adidas shoes for running", "nike kids running shoes"}},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.query, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := Query(context.Background(), index, store, config, c.query, Page{})
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if got := resultDocuments(res.Results); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected result\nGot: %v\nExpected: %v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestQuerySaturatedPostings(t *testing.T) {
+	t.Parallel()
+
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatalf("could not open leveldb: %v", err)
+	}
+	defer db.Close()
+
+	docs := testDocuments(500)
+	config := testConfig()
+	config.Postings = LevelDBPostings{DB: db}
+	store := LevelDBStore{DB: db}
+	if err := WriteDocuments(context.Background(), store, config, documentChan(docs)); err != nil {
+		t.Fatalf("could not write documents: %v", err)
+	}
+	tr := trie.NewTrie()
+	if err := BuildTrie(context.Background(), tr, config, documentChan(docs)); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	for _, query := range []string{"s shirt", "s d", "dr s blue"} {
+		expected := []string{}
+		for _, d := range docs {
+			if matchesAll(strings.Fields(d.Text), strings.Fields(query), config.MinSuffixLength) {
+				expected = append(expected, d.Text)
+			}
+		}
+		res, err := Query(context.Background(), tr, store, config, query, Page{})
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if res.Truncated {
+			t.Errorf("expected full posting lists to leave %q untruncated", query)
+		}
+		got := resultDocuments(res.Results)
+		sort.Strings(got)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected result for %q\nGot: %v\nExpected: %v", query, got, expected)
+		}
+	}
+}
+
+func TestPostingsCancelled(t *testing.T) {
+	t.Parallel()
+
+	entries := make([]uint64, 10*postingsCheckInterval)
+	for i := range entries {
+		entries[i] = uint64(i)
+	}
+	data := trie.EncodePostings(entries)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := drainPostings(ctx, trie.NewPostingIterator(data)); err != context.Canceled {
+		t.Errorf("expected draining a long list to be cancelled, got %v", err)
+	}
+	if _, err := intersectPostings(ctx, entries, trie.NewPostingIterator(data)); err != context.Canceled {
+		t.Errorf("expected intersecting a long list to be cancelled, got %v", err)
+	}
+	if got, err := drainPostings(context.Background(), trie.NewPostingIterator(data)); err != nil || !reflect.DeepEqual(got, entries) {
+		t.Errorf("unexpected drained list, error %v", err)
+	}
+}
+
+func TestQueryMemory(t *testing.T) {
+	t.Parallel()
+
+	docs := testDocuments(500)
+	config := testConfig()
+	config.IndexSuffixes = true
+	config.MinSuffixLength = 3
+	index := NewMemoryIndex()
+	store := buildTestIndex(t, config, docs, index)
+
+	for _, query := range []string{"shirt", "hirt blue", "sku12", "jacket dr"} {
+		expected := []string{}
+		for _, d := range docs {
+			if matchesAll(strings.Fields(d.Text), strings.Fields(query), config.MinSuffixLength) {
+				expected = append(expected, d.Text)
+			}
+		}
+		res, err := Query(context.Background(), index, store, config, query, Page{})
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		got := resultDocuments(res.Results)
+		sort.Strings(got)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected result for %q\nGot: %v\nExpected: %v", query, got, expected)
+		}
+	}
+}
+
+func TestQueryRanking(t *testing.T) {
+	t.Parallel()
+
+	docs := []Document{
+		{Text: "kids running shoes"},
+		{Text: "shoelaces"},
+		{Text: "shoe rack"},
+		{Text: "running shoe"},
+		{Text: "trail shoe", Score: 100},
+	}
+	config := testConfig()
+	config.MaxLexemeLength = 10
+	config.Ranking = DefaultRanking
+	index := NewMemoryIndex()
+	store := buildTestIndex(t, config, docs, index)
+
+	cases := []struct {
+		query    string
+		boosts   func(uint64) float64
+		expected []string
+	}{
+		// exact matches beat prefix ones, and earlier ones later ones
+		{query: "shoe", expected: []string{"shoe rack", "running shoe", "trail shoe", "shoelaces", "kids running shoes"}},
+		{query: "running shoe", expected: []string{"running shoe", "kids running shoes"}},
+		{
+			query:    "shoe",
+			boosts:   func(id uint64) float64 { return map[uint64]float64{4: 100}[id] },
+			expected: []string{"trail shoe", "shoe rack", "running shoe", "shoelaces", "kids running shoes"},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.query, func(t *testing.T) {
+			t.Parallel()
+
+			config := config
+			config.Ranking.Boosts = c.boosts
+			res, err := Query(context.Background(), index, store, config, c.query, Page{})
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if got := resultDocuments(res.Results); !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected ranking\nGot: %v\nExpected: %v", got, c.expected)
+			}
+			if res.Candidates != len(c.expected) {
+				t.Errorf("expected %v candidates, got %v", len(c.expected), res.Candidates)
+			}
+			for i := 1; i < len(res.Results); i++ {
+				if res.Results[i].Score > res.Results[i-1].Score {
+					t.Errorf("results aren't in score order: %v", res.Results)
+				}
+			}
+		})
+	}
+
+	// scores kept by a trie boost documents once it has been reloaded
+	tr := trie.NewTrie()
+	buildTestIndex(t, config, docs, tr)
+	loaded := trie.NewTrie()
+	loaded.SetMetadata(config.TrieMetadata())
+	if err := loaded.Unmarshal(bytes.NewReader(marshalTrie(t, tr))); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	res, err := Query(context.Background(), loaded, store, config, "shoe", Page{})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	expected := []string{"trail shoe", "shoe rack", "running shoe", "shoelaces", "kids running shoes"}
+	if got := resultDocuments(res.Results); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected ranking from a reloaded trie\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func TestQueryResultMatches(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig()
+	config.MaxBucketLength = 2
+	docs := []Document{{Text: "red shirt"}, {Text: "red shoe"}, {Text: "red sock"}}
+	index := trie.NewTrie()
+	store := buildTestIndex(t, config, docs, index)
+
+	res, err := Query(context.Background(), index, store, config, "sho r", Page{})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	expected := []Result{{ID: 1, Document: "red shoe", Matched: []string{"shoe", "red"}}}
+	if !reflect.DeepEqual(res.Results, expected) || res.Truncated {
+		t.Errorf("unexpected response\nGot: %#v\nExpected: %#v", res, expected)
+	}
+
+	// every bucket of "r" and "s" is full, so some matches may be lost
+	res, err = Query(context.Background(), index, store, config, "r s", Page{})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if !res.Truncated || res.Candidates != 2 {
+		t.Errorf("expected 2 candidates from truncated buckets, got %#v", res)
+	}
+}
+
+// countingStore counts the documents read from it.
+type countingStore struct {
+	DocumentStore
+	gets int
+}
+
+func (s *countingStore) Get(id uint64) (string, error) {
+	s.gets++
+	return s.DocumentStore.Get(id)
+}
+
+func TestQueryPage(t *testing.T) {
+	t.Parallel()
+
+	docs := testDocuments(500)
+	config := testConfig()
+	config.MaxBucketLength = 1000
+	index := NewMemoryIndex()
+	memStore := buildTestIndex(t, config, docs, index)
+
+	capped := DefaultRanking
+	capped.MaxCandidates = 50
+	for _, ranking := range []Ranking{{}, DefaultRanking, capped} {
+		config.Ranking = ranking
+		store := &countingStore{DocumentStore: memStore}
+		all, err := Query(context.Background(), index, store, config, "s", Page{})
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if len(all.Results) < 20 || all.NextOffset != 0 {
+			t.Fatalf("expected at least 20 results on one page, got %v", len(all.Results))
+		}
+		if max := ranking.MaxCandidates; max != 0 && all.Candidates > max && (store.gets > max || !all.Truncated) {
+			t.Errorf("expected at most %v of %v candidates read and the results truncated, got %v reads", max, all.Candidates, store.gets)
+		}
+
+		paged := []Result{}
+		for page := (Page{Limit: 7}); ; {
+			store.gets = 0
+			res, err := Query(context.Background(), index, store, config, "s", page)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if len(res.Results) > page.Limit {
+				t.Fatalf("expected at most %v results, got %v", page.Limit, len(res.Results))
+			}
+			if !ranking.ranks() && store.gets > page.Offset+page.Limit+1 {
+				t.Errorf("expected reads to stop once the page was full, got %v for %+v", store.gets, page)
+			}
+			paged = append(paged, res.Results...)
+			if res.NextOffset == 0 {
+				break
+			}
+			page.Offset = res.NextOffset
+		}
+		if !reflect.DeepEqual(paged, all.Results) {
+			t.Errorf("pages don't add up to the full results with %+v", ranking)
+		}
+	}
+}
+
+// cancellingStore cancels a query's context once it has read a number of
+// documents.
+type cancellingStore struct {
+	DocumentStore
+	after  int
+	cancel func()
+}
+
+func (s *cancellingStore) Get(id uint64) (string, error) {
+	if s.after--; s.after == 0 {
+		s.cancel()
+	}
+	return s.DocumentStore.Get(id)
+}
+
+func TestQueryCancelled(t *testing.T) {
+	t.Parallel()
+
+	docs := testDocuments(500)
+	config := testConfig()
+	config.MaxBucketLength = 1000
+	index := NewMemoryIndex()
+	memStore := buildTestIndex(t, config, docs, index)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Query(ctx, index, memStore, config, "s", Page{}); err != context.Canceled {
+		t.Errorf("expected a cancelled query to fail, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	// a ranked query doesn't stop reading once its page is full, so it is
+	// cut short
+	config.Ranking = DefaultRanking
+	store := &cancellingStore{DocumentStore: memStore, after: 5, cancel: cancel}
+	res, err := Query(ctx, index, store, config, "s", Page{Limit: 3})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if !res.Partial || len(res.Results) != 3 || res.Candidates <= 5 {
+		t.Errorf("expected 3 partial results, got %v of %v, partial: %v", len(res.Results), res.Candidates, res.Partial)
+	}
+	if res.NextOffset != 0 {
+		t.Errorf("expected no next page of partial results, got offset %v", res.NextOffset)
+	}
+}
+
+func resultDocuments(res []Result) []string {
+	docs := make([]string, len(res))
+	for i, r := range res {
+		docs[i] = r.Document
+	}
+	return docs
+}
+
+// matchesAll reports whether every prefix starts one of words or, if
+// minSuffixLength is set, is found long enough within one.
+func matchesAll(words, prefixes []string, minSuffixLength int) bool {
+	for _, p := range prefixes {
+		found := false
+		for _, w := range words {
+			if strings.HasPrefix(w, p) || (minSuffixLength > 0 && len(p) >= minSuffixLength && strings.Contains(w, p)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package triesbien
+
+import (
+	"math"
+	"strings"
+)
+
+// Ranking weighs the signals that Query orders its results by. Results
+// with equal scores are left in id order, so the zero Ranking leaves every
+// result where the index put it.
+type Ranking struct {
+	// Exact, Prefix and Infix score a query part that is a whole lexeme of
+	// the document, starts one, or is found within one when suffixes are
+	// indexed.
+	Exact, Prefix, Infix float64
+	// Coverage is scaled by the share of query parts the document matched,
+	// as fuzzy matches aren't found in the text of the document.
+	Coverage float64
+	// Position favours matches early in a field. A match on its first
+	// lexeme scores 1+Position times one far enough in not to matter.
+	Position float64
+	// Fields split the document into parts that matches are weighed by,
+	// such as its title and brand. The whole document is a single field of
+	// weight 1 if there are none.
+	Fields []Field
+	// Boost scales the logarithm of a document's boost, as given by Boosts
+	// or, failing that, by an index that keeps the scores of its documents.
+	Boost  float64
+	Boosts func(id uint64) float64
+	// MaxCandidates, if non zero, caps how many candidates a ranked query
+	// reads, as ranking otherwise reads every one. Candidates are read in id
+	// order, so past the cap better matches may be left out; the response
+	// is then marked as truncated.
+	MaxCandidates int
+}
+
+// Field is a part of a stored document whose matches carry Weight.
+type Field struct {
+	Name    string
+	Weight  float64
+	Extract func(doc string) string
+}
+
+// DefaultRanking puts documents matching more of the query, more exactly
+// and earlier ahead of the others. It ranks the first thousand candidates,
+// as reading tens of thousands would take longer than a query should.
+var DefaultRanking = Ranking{
+	Exact:         3,
+	Prefix:        2,
+	Infix:         1,
+	Coverage:      4,
+	Position:      1,
+	Boost:         1,
+	MaxCandidates: 1000,
+}
+
+// Scorer is implemented by indexes that keep document scores, such as
+// *trie.Trie, so that they can be used as boosts.
+type Scorer interface {
+	Score(entry uint64) float64
+}
+
+// ranks reports whether r orders results at all.
+func (r Ranking) ranks() bool {
+	return r.Exact != 0 || r.Prefix != 0 || r.Infix != 0 || r.Coverage != 0 || r.Boost != 0
+}
+
+// score rates how well doc matches the query parts, also returning the
+// lexemes of doc that matched them best.
+func (r Ranking) score(config Config, parts []string, id uint64, doc string, boosts func(uint64) float64) (float64, []string) {
+	fields := r.Fields
+	if len(fields) == 0 {
+		fields = []Field{{Weight: 1}}
+	}
+	lexemes := make([][]string, len(fields))
+	for i, f := range fields {
+		text := doc
+		if f.Extract != nil {
+			text = f.Extract(doc)
+		}
+		lexemes[i] = config.Parser(text)
+	}
+
+	score := 0.0
+	covered := 0
+	matched := []string{}
+	for _, part := range parts {
+		best, bestLexeme, found := 0.0, "", false
+		for i, f := range fields {
+			for pos, lexeme := range lexemes[i] {
+				var s float64
+				switch {
+				case lexeme == part:
+					s = r.Exact
+				case strings.HasPrefix(lexeme, part):
+					s = r.Prefix
+				case config.IndexSuffixes && strings.Contains(lexeme, part):
+					s = r.Infix
+				default:
+					continue
+				}
+				s *= f.Weight * (1 + r.Position/float64(pos+1))
+				if s > best || !found {
+					best, bestLexeme = s, lexeme
+				}
+				found = true
+			}
+		}
+		score += best
+		if !found {
+			continue
+		}
+		covered++
+		seen := false
+		for _, m := range matched {
+			seen = seen || m == bestLexeme
+		}
+		if !seen {
+			matched = append(matched, bestLexeme)
+		}
+	}
+	if len(parts) != 0 {
+		score += r.Coverage * float64(covered) / float64(len(parts))
+	}
+	if boosts != nil {
+		if b := boosts(id); b > 0 {
+			score += r.Boost * math.Log1p(b)
+		}
+	}
+	return score, matched
+}
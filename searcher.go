@@ -0,0 +1,85 @@
+package triesbien
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+// Snapshot is an index along with the documents and config it is queried
+// with. It must not be changed once it has been published to a Searcher.
+type Snapshot struct {
+	Index  Index
+	Docs   DocumentStore
+	Config Config
+	// Close, if set, releases the snapshot once it has been replaced and
+	// the last query using it has finished.
+	Close func() error
+}
+
+// Searcher publishes Snapshots to concurrent queries without locking. A
+// query runs to completion on the snapshot it started with, however many
+// times the snapshot is swapped in the meantime.
+type Searcher struct {
+	current atomic.Value // *snapshotRef
+}
+
+// snapshotRef counts the queries using a snapshot, plus one for as long as
+// it is published.
+type snapshotRef struct {
+	snap *Snapshot
+	refs int64
+}
+
+func NewSearcher(snap *Snapshot) *Searcher {
+	s := &Searcher{}
+	s.current.Store(&snapshotRef{snap: snap, refs: 1})
+	return s
+}
+
+// Swap publishes next for all new queries. The previous snapshot is closed
+// once the queries still using it have finished. Swap may be called
+// concurrently, each snapshot replaced being released exactly once.
+func (s *Searcher) Swap(next *Snapshot) {
+	prev := s.current.Swap(&snapshotRef{snap: next, refs: 1}).(*snapshotRef)
+	prev.release()
+}
+
+// Acquire returns the current snapshot, which stays open until the returned
+// func is called.
+func (s *Searcher) Acquire() (*Snapshot, func()) {
+	for {
+		ref := s.current.Load().(*snapshotRef)
+		n := atomic.LoadInt64(&ref.refs)
+		if n == 0 {
+			// replaced and closed since we loaded it
+			continue
+		}
+		if atomic.CompareAndSwapInt64(&ref.refs, n, n+1) {
+			return ref.snap, ref.release
+		}
+	}
+}
+
+// Query runs query against the current snapshot.
+func (s *Searcher) Query(ctx context.Context, query string, page Page) (*QueryResponse, error) {
+	snap, release := s.Acquire()
+	defer release()
+	return Query(ctx, snap.Index, snap.Docs, snap.Config, query, page)
+}
+
+// Close releases the current snapshot. The Searcher must not be used
+// afterwards.
+func (s *Searcher) Close() {
+	s.current.Load().(*snapshotRef).release()
+}
+
+func (r *snapshotRef) release() {
+	if atomic.AddInt64(&r.refs, -1) != 0 || r.snap.Close == nil {
+		return
+	}
+	if err := r.snap.Close(); err != nil {
+		glog.Errorf("could not close snapshot: %v", err)
+	}
+}
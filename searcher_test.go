@@ -0,0 +1,126 @@
+package triesbien
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSearcherSwap(t *testing.T) {
+	t.Parallel()
+
+	config := testConfig()
+	var open int64
+	snapshot := func(word string) *Snapshot {
+		docs := []Document{{Text: word + " sku0"}, {Text: word + " sku1"}}
+		index := NewMemoryIndex()
+		store := buildTestIndex(t, config, docs, index)
+		atomic.AddInt64(&open, 1)
+		closed := int64(0)
+		return &Snapshot{
+			Index:  index,
+			Docs:   store,
+			Config: config,
+			Close: func() error {
+				if atomic.AddInt64(&closed, 1) != 1 {
+					t.Errorf("snapshot of %v closed twice", word)
+				}
+				atomic.AddInt64(&open, -1)
+				return nil
+			},
+		}
+	}
+
+	s := NewSearcher(snapshot("gen0"))
+	held, release := s.Acquire()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				res, err := s.Query(context.Background(), "sku", Page{})
+				if err != nil {
+					t.Errorf("query failed: %v", err)
+					return
+				}
+				if len(res.Results) != 2 {
+					t.Errorf("expected 2 results from one snapshot, got %v", res.Results)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		s.Swap(snapshot(fmt.Sprintf("gen%d", i)))
+	}
+	wg.Wait()
+
+	// the first snapshot is still held, so only it and the current one
+	// are open
+	if got := atomic.LoadInt64(&open); got != 2 {
+		t.Errorf("expected 2 open snapshots, got %v", got)
+	}
+	res, err := Query(context.Background(), held.Index, held.Docs, held.Config, "gen0", Page{})
+	if err != nil || len(res.Results) != 2 {
+		t.Errorf("expected the held snapshot to still work, got %v, %v", res, err)
+	}
+	release()
+	s.Close()
+	if got := atomic.LoadInt64(&open); got != 0 {
+		t.Errorf("expected every snapshot closed, got %v open", got)
+	}
+}
+
+func TestSearcherConcurrentSwap(t *testing.T) {
+	t.Parallel()
+
+	var open int64
+	snapshot := func() *Snapshot {
+		atomic.AddInt64(&open, 1)
+		closed := int64(0)
+		return &Snapshot{
+			Close: func() error {
+				if atomic.AddInt64(&closed, 1) != 1 {
+					t.Errorf("snapshot closed twice")
+				}
+				atomic.AddInt64(&open, -1)
+				return nil
+			},
+		}
+	}
+
+	s := NewSearcher(snapshot())
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				s.Swap(snapshot())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				snap, release := s.Acquire()
+				if snap.Close == nil {
+					t.Errorf("acquired a snapshot that was never published")
+				}
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&open); got != 1 {
+		t.Errorf("expected only the current snapshot open, got %v", got)
+	}
+	s.Close()
+	if got := atomic.LoadInt64(&open); got != 0 {
+		t.Errorf("expected every snapshot closed, got %v open", got)
+	}
+}
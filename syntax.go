@@ -0,0 +1,346 @@
+package triesbien
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Queries are parsed from a small language. Terms next to each other must
+// all match, a|b matches either of a and b, -a excludes documents matching
+// a, "a b" matches a followed by b, and parentheses group terms. | binds
+// tighter than juxtaposition, so `"running shoes" -kids nike|adidas` finds
+// running shoes by either brand that aren't for kids. Each term and phrase
+// is split into query parts by the config's Parser. The parts of a term
+// must all match, in any order, so t-shirt finds whatever t and shirt
+// would; only a phrase needs them next to each other.
+//
+// A query must say what to find as well as what to exclude, so negated
+// terms can't stand alone or be alternatives.
+
+// ParseError is returned for queries that can't be parsed.
+type ParseError struct {
+	// Offset is the byte offset into the query the error was found at.
+	Offset int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at offset %v", e.Msg, e.Offset)
+}
+
+// queryNode is a node of a parsed query.
+type queryNode interface{}
+
+// partNode matches a query part as a lexeme prefix, or within a lexeme if
+// suffixes are indexed.
+type partNode struct {
+	part string
+	// fuzzy is set once the part has been fuzzy matched to documents by the
+	// index, as they won't contain it as it was written. Excluded parts are
+	// never fuzzy matched.
+	fuzzy bool
+}
+
+// phraseNode matches its parts on consecutive lexemes.
+type phraseNode struct {
+	parts []*partNode
+}
+
+type notNode struct {
+	node queryNode
+}
+
+type andNode struct {
+	nodes []queryNode
+}
+
+type orNode struct {
+	nodes []queryNode
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenPhrase
+	tokenNot
+	tokenOr
+	tokenOpen
+	tokenClose
+	tokenEnd
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func tokenize(query string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpen, offset: i})
+			i += size
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenClose, offset: i})
+			i += size
+		case r == '|':
+			tokens = append(tokens, token{kind: tokenOr, offset: i})
+			i += size
+		case r == '-':
+			tokens = append(tokens, token{kind: tokenNot, offset: i})
+			i += size
+		case r == '"':
+			end := i + 1
+			for end < len(query) && query[end] != '"' {
+				end++
+			}
+			if end == len(query) {
+				return nil, &ParseError{Offset: i, Msg: "unterminated phrase"}
+			}
+			tokens = append(tokens, token{kind: tokenPhrase, text: query[i+1 : end], offset: i})
+			i = end + 1
+		default:
+			end := i
+			for end < len(query) {
+				r, size := utf8.DecodeRuneInString(query[end:])
+				if unicode.IsSpace(r) || r == '(' || r == ')' || r == '|' || r == '"' {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: query[i:end], offset: i})
+			i = end
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, offset: len(query)}), nil
+}
+
+type queryParser struct {
+	config Config
+	tokens []token
+}
+
+// parseQuery parses query into a tree of nodes, which is nil if the query
+// has no parts at all.
+func parseQuery(config Config, query string) (queryNode, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{config: config, tokens: tokens}
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.tokens[0]; t.kind != tokenEnd {
+		return nil, &ParseError{Offset: t.offset, Msg: "unexpected )"}
+	}
+	return n, nil
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[0]
+	if t.kind != tokenEnd {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+// and parses terms until the end of the query or of a group.
+func (p *queryParser) and() (queryNode, error) {
+	offset := p.tokens[0].offset
+	nodes := []queryNode{}
+	positive := false
+	for k := p.tokens[0].kind; k != tokenEnd && k != tokenClose; k = p.tokens[0].kind {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			continue
+		}
+		if _, ok := n.(*notNode); !ok {
+			positive = true
+		}
+		nodes = append(nodes, n)
+	}
+	switch {
+	case len(nodes) == 0:
+		return nil, nil
+	case !positive:
+		return nil, &ParseError{Offset: offset, Msg: "nothing to find besides exclusions"}
+	case len(nodes) == 1:
+		return nodes[0], nil
+	}
+	return &andNode{nodes: nodes}, nil
+}
+
+func (p *queryParser) or() (queryNode, error) {
+	nodes := []queryNode{}
+	for {
+		offset := p.tokens[0].offset
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+		if p.tokens[0].kind != tokenOr {
+			break
+		}
+		if _, ok := n.(*notNode); ok {
+			return nil, &ParseError{Offset: offset, Msg: "exclusions can't be alternatives"}
+		}
+		p.next()
+	}
+	if len(nodes) > 1 {
+		if _, ok := nodes[len(nodes)-1].(*notNode); ok {
+			return nil, &ParseError{Offset: p.tokens[0].offset, Msg: "exclusions can't be alternatives"}
+		}
+	}
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return &orNode{nodes: nodes}, nil
+}
+
+func (p *queryParser) unary() (queryNode, error) {
+	if p.tokens[0].kind != tokenNot {
+		return p.primary()
+	}
+	p.next()
+	n, err := p.unary()
+	if n == nil || err != nil {
+		return nil, err
+	}
+	if not, ok := n.(*notNode); ok {
+		return not.node, nil
+	}
+	return &notNode{node: n}, nil
+}
+
+func (p *queryParser) primary() (queryNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenWord, tokenPhrase:
+		parts := p.config.Parser(t.text)
+		switch len(parts) {
+		case 0:
+			return nil, nil
+		case 1:
+			return &partNode{part: parts[0]}, nil
+		}
+		if t.kind == tokenWord {
+			and := &andNode{}
+			for _, part := range parts {
+				and.nodes = append(and.nodes, &partNode{part: part})
+			}
+			return and, nil
+		}
+		phrase := &phraseNode{}
+		for _, part := range parts {
+			phrase.parts = append(phrase.parts, &partNode{part: part})
+		}
+		return phrase, nil
+	case tokenOpen:
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if end := p.next(); end.kind != tokenClose {
+			return nil, &ParseError{Offset: t.offset, Msg: "unclosed ("}
+		}
+		return n, nil
+	case tokenEnd:
+		return nil, &ParseError{Offset: t.offset, Msg: "unexpected end of query"}
+	}
+	return nil, &ParseError{Offset: t.offset, Msg: fmt.Sprintf("unexpected %v", tokenNames[t.kind])}
+}
+
+var tokenNames = map[tokenKind]string{
+	tokenOr:    "|",
+	tokenClose: ")",
+}
+
+// positiveParts returns the parts of n that documents have to match, in
+// the order they were written.
+func positiveParts(n queryNode) []string {
+	switch n := n.(type) {
+	case *partNode:
+		return []string{n.part}
+	case *phraseNode:
+		parts := []string{}
+		for _, p := range n.parts {
+			parts = append(parts, p.part)
+		}
+		return parts
+	case *andNode:
+		parts := []string{}
+		for _, c := range n.nodes {
+			parts = append(parts, positiveParts(c)...)
+		}
+		return parts
+	case *orNode:
+		parts := []string{}
+		for _, c := range n.nodes {
+			parts = append(parts, positiveParts(c)...)
+		}
+		return parts
+	}
+	return nil
+}
+
+// matchNode reports whether a document made of lexemes matches n.
+func matchNode(config Config, n queryNode, lexemes []string) bool {
+	switch n := n.(type) {
+	case *partNode:
+		if n.fuzzy {
+			return true
+		}
+		for _, l := range lexemes {
+			if matchPart(config, l, n.part) {
+				return true
+			}
+		}
+		return false
+	case *phraseNode:
+	next:
+		for i := 0; i+len(n.parts) <= len(lexemes); i++ {
+			for j, p := range n.parts {
+				if !p.fuzzy && !matchPart(config, lexemes[i+j], p.part) {
+					continue next
+				}
+			}
+			return true
+		}
+		return false
+	case *notNode:
+		return !matchNode(config, n.node, lexemes)
+	case *andNode:
+		for _, c := range n.nodes {
+			if !matchNode(config, c, lexemes) {
+				return false
+			}
+		}
+		return true
+	case *orNode:
+		for _, c := range n.nodes {
+			if matchNode(config, c, lexemes) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package triesbien
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestParseQuery(t *testing.T) {
+	t.Parallel()
+
+	config := Config{Parser: func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '-' || r == '/'
+		})
+	}}
+
+	cases := []struct {
+		query    string
+		expected queryNode
+	}{
+		{
+			query:    `shirt`,
+			expected: &partNode{part: "shirt"},
+		},
+		{
+			// the parts of a term needn't be next to each other
+			query:    `t-shirt`,
+			expected: &andNode{nodes: []queryNode{&partNode{part: "t"}, &partNode{part: "shirt"}}},
+		},
+		{
+			query: `usb/c -"t-shirt"`,
+			expected: &andNode{nodes: []queryNode{
+				&andNode{nodes: []queryNode{&partNode{part: "usb"}, &partNode{part: "c"}}},
+				&notNode{node: &phraseNode{parts: []*partNode{{part: "t"}, {part: "shirt"}}}},
+			}},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.query, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseQuery(config, c.query)
+			if err != nil {
+				t.Fatalf("could not parse query: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected query tree\nGot: %#v\nExpected: %#v", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		query  string
+		offset int
+	}{
+		{query: `"running shoes`, offset: 0},
+		{query: `nike (shoes`, offset: 5},
+		{query: `nike shoes)`, offset: 10},
+		{query: `nike |`, offset: 6},
+		{query: `| nike`, offset: 0},
+		{query: `-kids`, offset: 0},
+		{query: `nike (-kids)`, offset: 6},
+		{query: `nike|-adidas`, offset: 12},
+		{query: `-nike|adidas`, offset: 0},
+		{query: `shoes -`, offset: 7},
+	}
+
+	for _, c := range cases {
+		_, err := parseQuery(Config{Parser: strings.Fields}, c.query)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("expected a parse error for %q, got %v", c.query, err)
+			continue
+		}
+		if perr.Offset != c.offset {
+			t.Errorf("expected %q to fail at offset %v, got %v", c.query, c.offset, perr)
+		}
+	}
+}
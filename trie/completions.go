@@ -0,0 +1,89 @@
+package trie
+
+import (
+	"container/heap"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+)
+
+// Completion is a whole lexeme stored in the trie, along with how many
+// documents contain it.
+type Completion struct {
+	Lexeme    string
+	Documents int
+}
+
+// Completions returns up to n of the whole lexemes starting with prefix,
+// those found in the most documents first.
+func (t *Trie) Completions(prefix []rune, n int) []Completion {
+	if n <= 0 {
+		return nil
+	}
+	path, _ := t.path(prefix)
+	node := path[len(path)-1]
+
+	// the prefix may stop part way along a label, in which case every
+	// completion carries the rest of it
+	value := []rune{}
+	for _, p := range path[1:] {
+		value = append(value, rune(p.Char))
+		for _, l := range p.Label {
+			value = append(value, rune(l))
+		}
+	}
+	if len(value) < len(prefix) || string(value[:len(prefix)]) != string(prefix) {
+		return nil
+	}
+
+	h := &completionHeap{}
+	collectCompletions(node, value, n, h)
+
+	res := make([]Completion, h.Len())
+	for i := len(res) - 1; i >= 0; i-- {
+		res[i] = heap.Pop(h).(Completion)
+	}
+	return res
+}
+
+func collectCompletions(n *trie_pb.Node, value []rune, max int, h *completionHeap) {
+	if n.Terminal {
+		c := Completion{Lexeme: string(value), Documents: int(n.DocumentCount)}
+		if h.Len() < max {
+			heap.Push(h, c)
+		} else if completionLess((*h)[0], c) {
+			(*h)[0] = c
+			heap.Fix(h, 0)
+		}
+	}
+	for _, child := range n.Children {
+		childValue := append(value, rune(child.Char))
+		for _, l := range child.Label {
+			childValue = append(childValue, rune(l))
+		}
+		collectCompletions(child, childValue, max, h)
+	}
+}
+
+// completionLess orders completions from worst to best: fewest documents
+// first, then reverse alphabetically.
+func completionLess(a, b Completion) bool {
+	if a.Documents != b.Documents {
+		return a.Documents < b.Documents
+	}
+	return a.Lexeme > b.Lexeme
+}
+
+// completionHeap is a min heap holding the best completions seen so far,
+// with the worst of them on top.
+type completionHeap []Completion
+
+func (h completionHeap) Len() int            { return len(h) }
+func (h completionHeap) Less(i, j int) bool  { return completionLess(h[i], h[j]) }
+func (h completionHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *completionHeap) Push(x interface{}) { *h = append(*h, x.(Completion)) }
+func (h *completionHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,94 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompletions(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	docs := []string{
+		"red dress",
+		"red dress dress",
+		"blue dress",
+		"drill",
+		"red drum",
+		"drills",
+		"dr",
+	}
+	for i, d := range docs {
+		for _, w := range splitWords(d) {
+			tr.Append([]rune(w), uint64(i))
+		}
+	}
+	tr.MergeUpwards(10)
+	tr.Compress()
+
+	cases := []struct {
+		prefix   string
+		n        int
+		expected []Completion
+	}{
+		{
+			prefix: "dr",
+			n:      3,
+			expected: []Completion{
+				{Lexeme: "dress", Documents: 3},
+				{Lexeme: "dr", Documents: 1},
+				{Lexeme: "drill", Documents: 1},
+			},
+		},
+		{
+			prefix: "dri",
+			n:      5,
+			expected: []Completion{
+				{Lexeme: "drill", Documents: 1},
+				{Lexeme: "drills", Documents: 1},
+			},
+		},
+		{
+			prefix:   "dre",
+			n:        5,
+			expected: []Completion{{Lexeme: "dress", Documents: 3}},
+		},
+		{
+			prefix:   "drx",
+			n:        5,
+			expected: nil,
+		},
+		{
+			prefix:   "re",
+			n:        0,
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.prefix, func(t *testing.T) {
+			t.Parallel()
+
+			got := tr.Completions([]rune(c.prefix), c.n)
+			if len(got) == 0 && len(c.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected completions\nGot: %v\nExpected: %v", got, c.expected)
+			}
+		})
+	}
+}
+
+func splitWords(s string) []string {
+	res := []string{}
+	start := 0
+	for i, r := range s {
+		if r == ' ' {
+			res = append(res, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(res, s[start:])
+}
@@ -0,0 +1,327 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+	"github.com/pkg/errors"
+)
+
+// DAWG is a read only index answering the same lookups as the trie it was
+// built from, in much less memory. It is the minimal automaton accepting
+// every prefix in the trie, so that subtrees with the same shape, such as
+// common endings, are stored once. Walking a prefix through it also counts
+// the prefixes that sort before it, which numbers every prefix; the number
+// picks out its posting list from a table in which identical lists are
+// stored once.
+type DAWG struct {
+	root uint32
+	// the edges of state s are firstEdge[s] up to firstEdge[s+1], sorted
+	// by char. Following edge e skips the prefixes numbered below it, of
+	// which there are skips[e].
+	firstEdge []uint32
+	chars     []uint32
+	targets   []uint32
+	skips     []uint32
+	// words maps a prefix's number to its posting list, the packed lists
+	// being postings[listOffsets[l]:listOffsets[l+1]].
+	words       []uint32
+	listOffsets []uint32
+	postings    []byte
+	// meta and scores are those of the trie the DAWG was built from
+	meta   Metadata
+	scores map[uint64]float64
+}
+
+// NewDAWG builds a DAWG holding the same prefixes and entries as t.
+func NewDAWG(t *Trie) *DAWG {
+	b := &dawgBuilder{
+		d: &DAWG{
+			firstEdge:   []uint32{0},
+			listOffsets: []uint32{0},
+			meta:        t.meta,
+			scores:      t.scores,
+		},
+		states: map[string]uint32{},
+		lists:  map[string]uint32{},
+	}
+	b.d.root = b.state(t.root.Children)
+	b.number(t.root)
+	return b.d
+}
+
+type dawgBuilder struct {
+	d      *DAWG
+	counts []uint32
+	states map[string]uint32
+	lists  map[string]uint32
+}
+
+// state registers the state reached through the given children, reusing an
+// equivalent one if it has been seen before.
+func (b *dawgBuilder) state(children []*trie_pb.Node) uint32 {
+	chars := make([]uint32, len(children))
+	targets := make([]uint32, len(children))
+	for i, c := range children {
+		// a label is a chain of states with one edge each
+		target := b.state(c.Children)
+		for j := len(c.Label) - 1; j >= 0; j-- {
+			target = b.chain(c.Label[j], target)
+		}
+		chars[i] = c.Char
+		targets[i] = target
+	}
+	return b.register(chars, targets)
+}
+
+func (b *dawgBuilder) chain(char uint32, target uint32) uint32 {
+	return b.register([]uint32{char}, []uint32{target})
+}
+
+func (b *dawgBuilder) register(chars, targets []uint32) uint32 {
+	key := make([]byte, 8*len(chars))
+	for i := range chars {
+		binary.LittleEndian.PutUint32(key[8*i:], chars[i])
+		binary.LittleEndian.PutUint32(key[8*i+4:], targets[i])
+	}
+	if s, ok := b.states[string(key)]; ok {
+		return s
+	}
+
+	d := b.d
+	s := uint32(len(b.counts))
+	count := uint32(1)
+	for i := range chars {
+		d.chars = append(d.chars, chars[i])
+		d.targets = append(d.targets, targets[i])
+		d.skips = append(d.skips, count)
+		count += b.counts[targets[i]]
+	}
+	d.firstEdge = append(d.firstEdge, uint32(len(d.chars)))
+	b.counts = append(b.counts, count)
+	b.states[string(key)] = s
+	return s
+}
+
+// number lists the posting list of every prefix below n in the order the
+// DAWG numbers them, which is the order a pre-order walk of the trie meets
+// them in.
+func (b *dawgBuilder) number(n *trie_pb.Node) {
+	list := b.list(nodeEntries(n))
+	for i := 0; i <= len(n.Label); i++ {
+		b.d.words = append(b.d.words, list)
+	}
+	for _, c := range n.Children {
+		b.number(c)
+	}
+}
+
+func (b *dawgBuilder) list(entries []uint64) uint32 {
+	packed := EncodePostings(entries)
+	if l, ok := b.lists[string(packed)]; ok {
+		return l
+	}
+	d := b.d
+	l := uint32(len(d.listOffsets) - 1)
+	d.postings = append(d.postings, packed...)
+	d.listOffsets = append(d.listOffsets, uint32(len(d.postings)))
+	b.lists[string(packed)] = l
+	return l
+}
+
+func (d *DAWG) Lookup(value []rune) []uint64 {
+	s := d.root
+	word := uint32(0)
+	for _, c := range value {
+		first, last := d.firstEdge[s], d.firstEdge[s+1]
+		ix := sort.Search(int(last-first), func(i int) bool {
+			return d.chars[first+uint32(i)] >= uint32(c)
+		})
+		e := first + uint32(ix)
+		if e == last || d.chars[e] != uint32(c) {
+			return nil
+		}
+		word += d.skips[e]
+		s = d.targets[e]
+	}
+	l := d.words[word]
+	return DecodePostings(d.postings[d.listOffsets[l]:d.listOffsets[l+1]])
+}
+
+// States returns the number of states in the automaton.
+func (d *DAWG) States() int {
+	return len(d.firstEdge) - 1
+}
+
+// The DAWG format follows the same header as other trie files, and is then,
+// in little endian uint32s:
+//
+//	header:   magic[8] version root stateCount edgeCount wordCount listCount postingBytes
+//	states:   stateCount+1 firstEdges
+//	edges:    edgeCount records of char target skip
+//	words:    wordCount list numbers
+//	lists:    listCount+1 offsets, then postingBytes of packed postings
+var dawgMagic = []byte("TRIEDAWG")
+
+const (
+	dawgVersion    = 1
+	dawgHeaderSize = 36
+)
+
+// Marshal writes the DAWG in the format read by UnmarshalDAWG.
+func (d *DAWG) Marshal(w io.Writer) error {
+	body := &bytes.Buffer{}
+	bw := bufio.NewWriter(body)
+	buf := make([]byte, 4)
+	put := func(vs ...uint32) {
+		for _, v := range vs {
+			binary.LittleEndian.PutUint32(buf, v)
+			bw.Write(buf)
+		}
+	}
+
+	bw.Write(dawgMagic)
+	put(dawgVersion, d.root, uint32(d.States()), uint32(len(d.chars)),
+		uint32(len(d.words)), uint32(len(d.listOffsets)-1), uint32(len(d.postings)))
+	put(d.firstEdge...)
+	for e := range d.chars {
+		put(d.chars[e], d.targets[e], d.skips[e])
+	}
+	put(d.words...)
+	put(d.listOffsets...)
+	bw.Write(d.postings)
+	bw.Flush()
+
+	header, err := encodeHeader(d.meta, d.scores, body.Bytes())
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return errors.Wrap(err, "could not write")
+	}
+	_, err = w.Write(body.Bytes())
+	return errors.Wrap(err, "could not write")
+}
+
+// UnmarshalDAWG reads a DAWG written by Marshal, checking its header against
+// expected as Unmarshal does. Files written before the format had a header
+// are only read if nothing is expected of them.
+func UnmarshalDAWG(r io.Reader, expected Metadata) (*DAWG, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read")
+	}
+	var meta Metadata
+	var scores map[uint64]float64
+	if hasHeader(data) || expected != (Metadata{}) {
+		meta, scores, data, err = decodeHeader(data, expected)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(data) < dawgHeaderSize || !bytes.Equal(data[0:8], dawgMagic) {
+		return nil, errors.New("not a DAWG")
+	}
+	header := readUint32s(data[8:dawgHeaderSize])
+	if header[0] != dawgVersion {
+		return nil, errors.Errorf("unsupported DAWG version %v", header[0])
+	}
+	root, states, edges, words, lists, postingBytes := header[1], uint64(header[2]), uint64(header[3]),
+		uint64(header[4]), uint64(header[5]), uint64(header[6])
+	size := dawgHeaderSize + 4*(states+1+3*edges+words+lists+1) + postingBytes
+	if uint64(len(data)) != size || root >= uint32(states) || words == 0 {
+		return nil, errors.New("DAWG is truncated or corrupt")
+	}
+
+	d := &DAWG{root: root, meta: meta, scores: scores}
+	off := uint64(dawgHeaderSize)
+	next := func(n uint64) []uint32 {
+		vs := readUint32s(data[off : off+4*n])
+		off += 4 * n
+		return vs
+	}
+	d.firstEdge = next(states + 1)
+	edgeData := next(3 * edges)
+	d.words = next(words)
+	d.listOffsets = next(lists + 1)
+	d.postings = data[off:]
+
+	d.chars = make([]uint32, edges)
+	d.targets = make([]uint32, edges)
+	d.skips = make([]uint32, edges)
+	for e := range d.chars {
+		d.chars[e], d.targets[e], d.skips[e] = edgeData[3*e], edgeData[3*e+1], edgeData[3*e+2]
+	}
+	if err := d.validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Metadata returns the metadata of the trie the DAWG was built from.
+func (d *DAWG) Metadata() Metadata {
+	return d.meta
+}
+
+// Score returns the score the trie's buckets ranked entry by.
+func (d *DAWG) Score(entry uint64) float64 {
+	return d.scores[entry]
+}
+
+// validate checks every index in the DAWG is in range, so that lookups on a
+// corrupt file can't panic.
+func (d *DAWG) validate() error {
+	corrupt := errors.New("DAWG is corrupt")
+	for s := 0; s < d.States(); s++ {
+		if d.firstEdge[s] > d.firstEdge[s+1] {
+			return corrupt
+		}
+	}
+	if d.firstEdge[0] != 0 || d.firstEdge[d.States()] != uint32(len(d.chars)) {
+		return corrupt
+	}
+	// with the skips counting what they should, every prefix walked from
+	// the root has a number below the root's count
+	counts := make([]uint64, d.States())
+	for s := range counts {
+		counts[s] = 1
+		for e := d.firstEdge[s]; e < d.firstEdge[s+1]; e++ {
+			t := d.targets[e]
+			// states are written children first
+			if int(t) >= s || uint64(d.skips[e]) != counts[s] {
+				return corrupt
+			}
+			counts[s] += counts[t]
+		}
+	}
+	if counts[d.root] != uint64(len(d.words)) {
+		return corrupt
+	}
+	for _, l := range d.words {
+		if uint64(l)+1 >= uint64(len(d.listOffsets)) {
+			return corrupt
+		}
+	}
+	for l := 1; l < len(d.listOffsets); l++ {
+		if d.listOffsets[l-1] > d.listOffsets[l] {
+			return corrupt
+		}
+	}
+	if uint64(d.listOffsets[len(d.listOffsets)-1]) != uint64(len(d.postings)) {
+		return corrupt
+	}
+	return nil
+}
+
+func readUint32s(data []byte) []uint32 {
+	res := make([]uint32, len(data)/4)
+	for i := range res {
+		res[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	return res
+}
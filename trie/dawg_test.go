@@ -0,0 +1,93 @@
+package trie
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDAWGLookup(t *testing.T) {
+	t.Parallel()
+
+	tr, lexemes := benchCatalogue(2000)
+	meta := Metadata{MaxLexemeLength: 10, Parser: "bench", DocumentCount: 2000}
+	tr.SetMetadata(meta)
+	tr.SetScore(7, 20)
+	tr.Compress()
+	d := NewDAWG(tr)
+	if d.States() >= len(d.words) {
+		t.Errorf("expected fewer states than prefixes, got %v against %v", d.States(), len(d.words))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := d.Marshal(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if _, err := UnmarshalDAWG(bytes.NewReader(buf.Bytes()), Metadata{Parser: "other"}); err == nil {
+		t.Errorf("expected a DAWG built with another parser to be rejected")
+	}
+	loaded, err := UnmarshalDAWG(buf, Metadata{MaxLexemeLength: 10, Parser: "bench"})
+	if err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if got := loaded.Metadata(); !reflect.DeepEqual(got, meta) {
+		t.Errorf("unexpected metadata\nGot: %+v\nExpected: %+v", got, meta)
+	}
+	if got := loaded.Score(7); got != 20 {
+		t.Errorf("expected entry 7 to keep its score of 20, got %v", got)
+	}
+
+	queries := append([][]rune{[]rune(""), []rune("zzzzzzzzzzzz")}, allPrefixes(lexemes)...)
+	for _, q := range queries {
+		expected := tr.Lookup(q)
+		for _, got := range [][]uint64{d.Lookup(q), loaded.Lookup(q)} {
+			if len(expected) == 0 && len(got) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("unexpected lookup of %q\nGot: %v\nExpected: %v", string(q), got, expected)
+			}
+		}
+	}
+}
+
+func TestDAWGSharesEndings(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	for i, l := range []string{"walking", "talking", "running", "walker", "talker"} {
+		tr.Append([]rune(l), uint64(i))
+	}
+	tr.MergeUpwards(10)
+	tr.Compress()
+
+	// walk and talk share "alk", and all three share "ing", leaving 13
+	// states against the trie's 26 prefixes
+	d := NewDAWG(tr)
+	if d.States() != 13 {
+		t.Errorf("expected 13 states, got %v", d.States())
+	}
+}
+
+func TestUnmarshalDAWGRejectsCorrupt(t *testing.T) {
+	t.Parallel()
+
+	tr, _ := benchCatalogue(10)
+	buf := &bytes.Buffer{}
+	if err := NewDAWG(tr).Marshal(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	for i := range data {
+		corrupt := append([]byte{}, data...)
+		corrupt[i] ^= 0xff
+		if d, err := UnmarshalDAWG(bytes.NewReader(corrupt), Metadata{}); err == nil {
+			// whatever got through must still be safe to search
+			d.Lookup([]rune("abc"))
+		}
+	}
+	if _, err := UnmarshalDAWG(bytes.NewReader(data[:len(data)-1]), Metadata{}); err == nil {
+		t.Errorf("expected an error reading a truncated DAWG")
+	}
+}
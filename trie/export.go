@@ -0,0 +1,146 @@
+package trie
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+	"github.com/pkg/errors"
+)
+
+type ExportFormat int
+
+const (
+	ExportJSON ExportFormat = iota
+	ExportDOT
+)
+
+// ParseExportFormat returns the format named by s, json or dot.
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch s {
+	case "json":
+		return ExportJSON, nil
+	case "dot":
+		return ExportDOT, nil
+	default:
+		return 0, errors.Errorf("unknown export format %v", s)
+	}
+}
+
+type exportNode struct {
+	Prefix    string        `json:"prefix"`
+	Edge      string        `json:"edge,omitempty"`
+	Terminal  bool          `json:"terminal,omitempty"`
+	Documents uint32        `json:"documents,omitempty"`
+	Entries   int           `json:"entries"`
+	IDs       []uint64      `json:"ids,omitempty"`
+	Children  []*exportNode `json:"children,omitempty"`
+	// Elided counts the children left out below the depth limit.
+	Elided int `json:"elided,omitempty"`
+}
+
+// Export writes the subtree holding prefix, down to depth nodes below it, for
+// debugging. A negative depth writes the whole subtree. Nodes are described
+// by their edge, bucket length and, if withIDs is set, the ids in it.
+func (t *Trie) Export(w io.Writer, prefix []rune, depth int, format ExportFormat, withIDs bool) error {
+	path, found := t.path(prefix)
+	n := path[len(path)-1]
+	value := []rune{}
+	for _, p := range path[1:] {
+		value = append(value, rune(p.Char))
+		for _, l := range p.Label {
+			value = append(value, rune(l))
+		}
+	}
+	// a prefix ending part way along a label still belongs to its node
+	if !found && !strings.HasPrefix(string(value), string(prefix)) {
+		return errors.Errorf("%q is not in the trie", string(prefix))
+	}
+	root := exportSubtree(n, value, depth, withIDs)
+
+	switch format {
+	case ExportJSON:
+		data, err := json.MarshalIndent(root, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "could not encode trie")
+		}
+		_, err = w.Write(append(data, '\n'))
+		return errors.Wrap(err, "could not write")
+	case ExportDOT:
+		bw := bufio.NewWriter(w)
+		fmt.Fprintln(bw, "digraph trie {")
+		writeDOT(bw, root, new(int))
+		fmt.Fprintln(bw, "}")
+		return errors.Wrap(bw.Flush(), "could not write")
+	default:
+		return errors.Errorf("unknown export format %v", format)
+	}
+}
+
+func exportSubtree(n *trie_pb.Node, value []rune, depth int, withIDs bool) *exportNode {
+	entries := nodeEntries(n)
+	e := &exportNode{
+		Prefix:    string(value),
+		Terminal:  n.Terminal,
+		Documents: n.DocumentCount,
+		Entries:   len(entries),
+	}
+	if len(value) != 0 {
+		e.Edge = string(value[len(value)-1-len(n.Label):])
+	}
+	if withIDs {
+		e.IDs = entries
+	}
+	if depth == 0 {
+		e.Elided = len(n.Children)
+		return e
+	}
+	for _, c := range n.Children {
+		childValue := append(append([]rune{}, value...), rune(c.Char))
+		for _, l := range c.Label {
+			childValue = append(childValue, rune(l))
+		}
+		e.Children = append(e.Children, exportSubtree(c, childValue, depth-1, withIDs))
+	}
+	return e
+}
+
+// writeDOT writes e and its descendants as nodes numbered from *next,
+// returning e's number.
+func writeDOT(w io.Writer, e *exportNode, next *int) int {
+	id := *next
+	*next++
+
+	label := fmt.Sprintf("%s\\n%d entries", dotEscape(e.Prefix), e.Entries)
+	if e.Terminal {
+		label += fmt.Sprintf("\\n%d documents", e.Documents)
+	}
+	if len(e.IDs) != 0 {
+		ids := make([]string, len(e.IDs))
+		for i, v := range e.IDs {
+			ids[i] = fmt.Sprint(v)
+		}
+		label += "\\n" + strings.Join(ids, ",")
+	}
+	if e.Elided != 0 {
+		label += fmt.Sprintf("\\n(%d children elided)", e.Elided)
+	}
+	shape := "ellipse"
+	if e.Terminal {
+		shape = "doublecircle"
+	}
+	fmt.Fprintf(w, "\tn%d [label=\"%s\", shape=%s];\n", id, label, shape)
+
+	for _, c := range e.Children {
+		cid := writeDOT(w, c, next)
+		fmt.Fprintf(w, "\tn%d -> n%d [label=\"%s\"];\n", id, cid, dotEscape(c.Edge))
+	}
+	return id
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,54 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.Append([]rune("shirt"), 1)
+	tr.Append([]rune("shoe"), 2)
+	tr.Append([]rune("tshirt"), 3)
+	tr.MergeUpwards(10)
+	tr.Compress()
+
+	buf := &bytes.Buffer{}
+	if err := tr.Export(buf, []rune("sh"), 1, ExportJSON, true); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	got := &exportNode{}
+	if err := json.Unmarshal(buf.Bytes(), got); err != nil {
+		t.Fatalf("could not decode export: %v", err)
+	}
+	expected := &exportNode{
+		Prefix:  "sh",
+		Edge:    "sh",
+		Entries: 2,
+		IDs:     []uint64{1, 2},
+		Children: []*exportNode{
+			{Prefix: "shirt", Edge: "irt", Terminal: true, Documents: 1, Entries: 1, IDs: []uint64{1}},
+			{Prefix: "shoe", Edge: "oe", Terminal: true, Documents: 1, Entries: 1, IDs: []uint64{2}},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected result\nGot: %v\nExpected: %v", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := tr.Export(buf, nil, -1, ExportDOT, false); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "digraph trie {") || strings.Count(buf.String(), "->") != 4 {
+		t.Errorf("unexpected dot output\n%v", buf.String())
+	}
+
+	if err := tr.Export(buf, []rune("shx"), 1, ExportJSON, false); err == nil {
+		t.Errorf("expected an error exporting a missing prefix")
+	}
+}
@@ -0,0 +1,156 @@
+package trie
+
+import (
+	"sort"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+)
+
+// FuzzyMatch is a prefix stored in the trie that is within some edit
+// distance of a looked up value.
+type FuzzyMatch struct {
+	Lexeme   string
+	Distance int
+}
+
+type fuzzyResult struct {
+	node  *trie_pb.Node
+	match FuzzyMatch
+}
+
+// FuzzyLookup finds the prefixes stored in the trie that are within maxEdits
+// insertions, deletions or substitutions of value, returning the union of
+// their entries along with the matched prefixes. Where a prefix and one of
+// its extensions both match, only the closer of the two is kept, preferring
+// the longer on a tie.
+func (t *Trie) FuzzyLookup(value []rune, maxEdits int) ([]uint64, []FuzzyMatch) {
+	row := make([]int, len(value)+1)
+	for i := range row {
+		row[i] = i
+	}
+
+	var results []fuzzyResult
+	childBest := -1
+	for _, child := range t.root.Children {
+		res, d := fuzzyWalk(child, value, maxEdits, row, nil)
+		results = append(results, res...)
+		if len(res) != 0 && (childBest == -1 || d < childBest) {
+			childBest = d
+		}
+	}
+	if row[len(value)] <= maxEdits && (childBest == -1 || row[len(value)] < childBest) {
+		results = []fuzzyResult{{node: t.root, match: FuzzyMatch{Distance: row[len(value)]}}}
+	}
+
+	entries := []uint64{}
+	matches := make([]FuzzyMatch, len(results))
+	for i, r := range results {
+		entries = mergeEntries(entries, nodeEntries(r.node))
+		matches[i] = r.match
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Lexeme < matches[j].Lexeme
+	})
+	return entries, matches
+}
+
+// fuzzyWalk runs the Levenshtein automaton for value over n and its
+// descendants, given the row for n's parent. It returns the best matches in
+// the subtree and the smallest distance among them.
+func fuzzyWalk(n *trie_pb.Node, value []rune, maxEdits int, prevRow []int, prefix []rune) ([]fuzzyResult, int) {
+	prefix = append(prefix, rune(n.Char))
+	row := nextRow(prevRow, value, rune(n.Char))
+
+	// the edge into n is a chain of runes that all share n's entries, so
+	// n matches at the closest point along it
+	best := -1
+	bestLen := 0
+	complete := true
+	if row[len(value)] <= maxEdits {
+		best = row[len(value)]
+		bestLen = len(prefix)
+	}
+	for _, l := range n.Label {
+		if minInt(row) > maxEdits {
+			complete = false
+			break
+		}
+		prefix = append(prefix, rune(l))
+		row = nextRow(row, value, rune(l))
+		if row[len(value)] <= maxEdits && (best == -1 || row[len(value)] <= best) {
+			best = row[len(value)]
+			bestLen = len(prefix)
+		}
+	}
+
+	var results []fuzzyResult
+	childBest := -1
+	if complete && minInt(row) <= maxEdits {
+		for _, child := range n.Children {
+			res, d := fuzzyWalk(child, value, maxEdits, row, prefix)
+			if len(res) == 0 {
+				continue
+			}
+			results = append(results, res...)
+			if childBest == -1 || d < childBest {
+				childBest = d
+			}
+		}
+	}
+
+	if best != -1 && (childBest == -1 || best < childBest) {
+		return []fuzzyResult{{
+			node:  n,
+			match: FuzzyMatch{Lexeme: string(prefix[:bestLen]), Distance: best},
+		}}, best
+	}
+	return results, childBest
+}
+
+func nextRow(prev []int, value []rune, c rune) []int {
+	row := make([]int, len(prev))
+	row[0] = prev[0] + 1
+	for i := 1; i < len(row); i++ {
+		cost := 1
+		if value[i-1] == c {
+			cost = 0
+		}
+		row[i] = minInt([]int{row[i-1] + 1, prev[i] + 1, prev[i-1] + cost})
+	}
+	return row
+}
+
+func minInt(vs []int) int {
+	m := vs[0]
+	for _, v := range vs[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// mergeEntries returns the sorted union of two sorted entry lists.
+func mergeEntries(a, b []uint64) []uint64 {
+	res := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			res = append(res, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			res = append(res, a[i])
+			i++
+		default:
+			res = append(res, b[j])
+			j++
+		}
+	}
+	res = append(res, a[i:]...)
+	return append(res, b[j:]...)
+}
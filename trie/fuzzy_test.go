@@ -0,0 +1,72 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyLookup(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.Append([]rune("adidas"), 1)
+	tr.Append([]rune("tshirt"), 2)
+	tr.Append([]rune("tshirts"), 3)
+	tr.Append([]rune("shirt"), 4)
+	tr.Append([]rune("shoe"), 5)
+	tr.MergeUpwards(10)
+	tr.Compress()
+
+	cases := []struct {
+		value           string
+		maxEdits        int
+		expected        []uint64
+		expectedMatches []FuzzyMatch
+	}{
+		{
+			value:           "addidas",
+			maxEdits:        1,
+			expected:        []uint64{1},
+			expectedMatches: []FuzzyMatch{{Lexeme: "adidas", Distance: 1}},
+		},
+		{
+			value:           "tshrit",
+			maxEdits:        2,
+			expected:        []uint64{2, 3},
+			expectedMatches: []FuzzyMatch{{Lexeme: "tshirt", Distance: 2}},
+		},
+		{
+			value:           "shirt",
+			maxEdits:        1,
+			expected:        []uint64{2, 3, 4},
+			expectedMatches: []FuzzyMatch{{Lexeme: "shirt", Distance: 0}, {Lexeme: "tshirt", Distance: 1}},
+		},
+		{
+			value:           "shoo",
+			maxEdits:        1,
+			expected:        []uint64{5},
+			expectedMatches: []FuzzyMatch{{Lexeme: "shoe", Distance: 1}},
+		},
+		{
+			value:           "jacket",
+			maxEdits:        2,
+			expected:        []uint64{},
+			expectedMatches: []FuzzyMatch{},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.value, func(t *testing.T) {
+			t.Parallel()
+
+			got, matches := tr.FuzzyLookup([]rune(c.value), c.maxEdits)
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("unexpected result\nGot: %v\nExpected: %v", got, c.expected)
+			}
+			if !reflect.DeepEqual(matches, c.expectedMatches) {
+				t.Errorf("unexpected matches\nGot: %v\nExpected: %v", matches, c.expectedMatches)
+			}
+		})
+	}
+}
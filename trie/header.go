@@ -0,0 +1,227 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"sort"
+	"time"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+	"github.com/pkg/errors"
+)
+
+// Trie files start with headerMagic, then the length of the encoded Header
+// as a uvarint, the Header itself and finally the root Node.
+var headerMagic = []byte("TRIESBN\x00")
+
+const headerVersion = 1
+
+// Metadata describes how a trie was built. It is stored in the header of the
+// trie file.
+type Metadata struct {
+	MaxLexemeLength int
+	MaxBucketLength int
+	Parser          string
+	// IndexSuffixes is set if suffixes of at least MinSuffixLength runes
+	// were indexed along with lexemes.
+	IndexSuffixes   bool
+	MinSuffixLength int
+	DocumentCount   uint64
+	BuiltAt         time.Time
+}
+
+// Metadata returns the metadata the trie was built or loaded with.
+func (t *Trie) Metadata() Metadata {
+	return t.meta
+}
+
+// SetMetadata sets the metadata Marshal writes to the file header. Before
+// Unmarshal it sets what the file is expected to hold, as checked by
+// decodeHeader.
+func (t *Trie) SetMetadata(meta Metadata) {
+	t.meta = meta
+	if meta.MaxBucketLength != 0 {
+		t.maxEntries = meta.MaxBucketLength
+	}
+}
+
+func (t *Trie) encodeHeader(body []byte) ([]byte, error) {
+	return encodeHeader(t.meta, t.scores, body)
+}
+
+// decodeHeader checks the header at the start of data against the body
+// following it and the trie's expected metadata. It returns the body.
+func (t *Trie) decodeHeader(data []byte) ([]byte, error) {
+	meta, scores, body, err := decodeHeader(data, t.meta)
+	if err != nil {
+		return nil, err
+	}
+	t.SetMetadata(meta)
+	t.scores = scores
+	return body, nil
+}
+
+// encodeHeader returns the header of a file holding body, for a trie built
+// with meta and scores, which the header holds.
+func encodeHeader(meta Metadata, scores map[uint64]float64, body []byte) ([]byte, error) {
+	packedScores := encodeScores(scores)
+	checksum := crc32.Update(crc32.ChecksumIEEE(body), crc32.IEEETable, packedScores)
+	return marshalHeader(meta, checksum, packedScores)
+}
+
+// marshalHeader returns a header holding meta, checksum and packed scores, for
+// formats that check and store what follows the header in their own way.
+func marshalHeader(meta Metadata, checksum uint32, packedScores []byte) ([]byte, error) {
+	h := &trie_pb.Header{
+		Version:         headerVersion,
+		MaxLexemeLength: uint32(meta.MaxLexemeLength),
+		MaxBucketLength: uint32(meta.MaxBucketLength),
+		Parser:          meta.Parser,
+		IndexSuffixes:   meta.IndexSuffixes,
+		MinSuffixLength: uint32(meta.MinSuffixLength),
+		DocumentCount:   meta.DocumentCount,
+		Checksum:        checksum,
+		Scores:          packedScores,
+	}
+	if !meta.BuiltAt.IsZero() {
+		h.BuiltAt = meta.BuiltAt.UnixNano()
+	}
+	hb, err := h.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal header")
+	}
+
+	buf := make([]byte, len(headerMagic)+binary.MaxVarintLen64+len(hb))
+	n := copy(buf, headerMagic)
+	n += binary.PutUvarint(buf[n:], uint64(len(hb)))
+	n += copy(buf[n:], hb)
+	return buf[:n], nil
+}
+
+// hasHeader reports whether data starts with a header, as files of the
+// mapped and DAWG formats written before they had one don't.
+func hasHeader(data []byte) bool {
+	return bytes.HasPrefix(data, headerMagic)
+}
+
+// decodeHeader checks the header at the start of data against the body
+// following it and the expected metadata, any non zero lexeme length,
+// bucket length or parser of which must match. As not indexing suffixes is
+// as much a setting as indexing them, the suffix settings must match too if
+// anything else is expected. It returns the metadata and scores in the
+// header, and the body.
+func decodeHeader(data []byte, expected Metadata) (Metadata, map[uint64]float64, []byte, error) {
+	h, meta, body, err := readHeader(data, expected)
+	if err != nil {
+		return Metadata{}, nil, nil, err
+	}
+	if sum := crc32.Update(crc32.ChecksumIEEE(body), crc32.IEEETable, h.Scores); sum != h.Checksum {
+		return Metadata{}, nil, nil, errors.Errorf("trie file is corrupt, checksum %08x doesn't match header's %08x", sum, h.Checksum)
+	}
+	scores, err := decodeScores(h.Scores)
+	if err != nil {
+		return Metadata{}, nil, nil, err
+	}
+	return meta, scores, body, nil
+}
+
+// readHeader reads the header at the start of data and checks its metadata
+// against expected, leaving its checksum to be checked by the caller. It
+// returns the header, its metadata and the rest of data.
+func readHeader(data []byte, expected Metadata) (*trie_pb.Header, Metadata, []byte, error) {
+	if !hasHeader(data) {
+		return nil, Metadata{}, nil, errors.New("trie file has no header, it may predate headers and need rebuilding")
+	}
+	data = data[len(headerMagic):]
+	l, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < l {
+		return nil, Metadata{}, nil, errors.New("trie file header is truncated")
+	}
+	h := &trie_pb.Header{}
+	if err := h.Unmarshal(data[n : n+int(l)]); err != nil {
+		return nil, Metadata{}, nil, errors.Wrap(err, "could not unmarshal header")
+	}
+	if h.Version != headerVersion {
+		return nil, Metadata{}, nil, errors.Errorf("unsupported trie file version %v, expected %v", h.Version, headerVersion)
+	}
+
+	meta := Metadata{
+		MaxLexemeLength: int(h.MaxLexemeLength),
+		MaxBucketLength: int(h.MaxBucketLength),
+		Parser:          h.Parser,
+		IndexSuffixes:   h.IndexSuffixes,
+		MinSuffixLength: int(h.MinSuffixLength),
+		DocumentCount:   h.DocumentCount,
+	}
+	if h.BuiltAt != 0 {
+		meta.BuiltAt = time.Unix(0, h.BuiltAt)
+	}
+	if err := expected.check(meta); err != nil {
+		return nil, Metadata{}, nil, err
+	}
+	return h, meta, data[n+int(l):], nil
+}
+
+// check returns an error if meta doesn't match what is expected.
+func (expected Metadata) check(meta Metadata) error {
+	if expected.MaxLexemeLength != 0 && expected.MaxLexemeLength != meta.MaxLexemeLength {
+		return errors.Errorf("trie was built with lexeme length %v, expected %v", meta.MaxLexemeLength, expected.MaxLexemeLength)
+	}
+	if expected.MaxBucketLength != 0 && expected.MaxBucketLength != meta.MaxBucketLength {
+		return errors.Errorf("trie was built with bucket length %v, expected %v", meta.MaxBucketLength, expected.MaxBucketLength)
+	}
+	if expected.Parser != "" && expected.Parser != meta.Parser {
+		return errors.Errorf("trie was built with parser %q, expected %q", meta.Parser, expected.Parser)
+	}
+	if expected.MaxLexemeLength == 0 && expected.MaxBucketLength == 0 && expected.Parser == "" && !expected.IndexSuffixes {
+		return nil
+	}
+	if expected.IndexSuffixes != meta.IndexSuffixes {
+		return errors.Errorf("trie was built with suffix indexing %v, expected %v", meta.IndexSuffixes, expected.IndexSuffixes)
+	}
+	if expected.MinSuffixLength != meta.MinSuffixLength {
+		return errors.Errorf("trie was built with minimum suffix length %v, expected %v", meta.MinSuffixLength, expected.MinSuffixLength)
+	}
+	return nil
+}
+
+// encodeScores packs scores as a uvarint id delta and the float64 bits of
+// each score, in id order, so that the file is the same for the same trie.
+func encodeScores(scores map[uint64]float64) []byte {
+	ids := make([]uint64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	buf := make([]byte, 0, len(ids)*(binary.MaxVarintLen64+8))
+	tmp := make([]byte, binary.MaxVarintLen64)
+	prev := uint64(0)
+	for _, id := range ids {
+		buf = append(buf, tmp[:binary.PutUvarint(tmp, id-prev)]...)
+		binary.LittleEndian.PutUint64(tmp, math.Float64bits(scores[id]))
+		buf = append(buf, tmp[:8]...)
+		prev = id
+	}
+	return buf
+}
+
+func decodeScores(data []byte) (map[uint64]float64, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	scores := map[uint64]float64{}
+	id := uint64(0)
+	for len(data) != 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 || len(data) < n+8 {
+			return nil, errors.New("trie file scores are truncated")
+		}
+		id += delta
+		scores[id] = math.Float64frombits(binary.LittleEndian.Uint64(data[n:]))
+		data = data[n+8:]
+	}
+	return scores, nil
+}
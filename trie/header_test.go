@@ -0,0 +1,72 @@
+package trie
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+	t.Parallel()
+
+	tr, _ := benchCatalogue(100)
+	built := time.Unix(1500000000, 0)
+	tr.SetMetadata(Metadata{
+		MaxLexemeLength: 10,
+		MaxBucketLength: 64,
+		Parser:          "words",
+		IndexSuffixes:   true,
+		MinSuffixLength: 2,
+		DocumentCount:   100,
+		BuiltAt:         built,
+	})
+	buf := &bytes.Buffer{}
+	if err := tr.Marshal(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	loaded := NewTrie()
+	loaded.SetMetadata(Metadata{MaxLexemeLength: 10, MaxBucketLength: 64, Parser: "words", IndexSuffixes: true, MinSuffixLength: 2})
+	if err := loaded.Unmarshal(bytes.NewReader(data)); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if got := loaded.Metadata(); !reflect.DeepEqual(got, tr.Metadata()) || !got.BuiltAt.Equal(built) {
+		t.Errorf("unexpected metadata\nGot: %+v\nExpected: %+v", got, tr.Metadata())
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected Metadata
+		err      string
+	}{
+		{name: "no header", data: data[len(headerMagic)+20:], err: "no header"},
+		{name: "truncated", data: data[:len(headerMagic)+3], err: "truncated"},
+		{name: "corrupt", data: corrupt, err: "checksum"},
+		{name: "lexeme length", data: data, expected: Metadata{MaxLexemeLength: 12}, err: "lexeme length 10, expected 12"},
+		{name: "bucket length", data: data, expected: Metadata{MaxBucketLength: 128}, err: "bucket length 64, expected 128"},
+		{name: "parser", data: data, expected: Metadata{Parser: "letters"}, err: `parser "words"`},
+		{name: "suffixes", data: data, expected: Metadata{Parser: "words"}, err: "suffix indexing true, expected false"},
+		{name: "suffix length", data: data, expected: Metadata{IndexSuffixes: true, MinSuffixLength: 3}, err: "minimum suffix length 2, expected 3"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := NewTrie()
+			tr.SetMetadata(c.expected)
+			err := tr.Unmarshal(bytes.NewReader(c.data))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("unexpected error\nGot: %v\nExpected: %v", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+	"github.com/pkg/errors"
+)
+
+// The mapped format lays a trie out flat so that it can be memory mapped and
+// searched in place. It follows the same header as other trie files, except
+// that the header's checksum covers only the scores section, so that opening
+// a file doesn't read all of it. The body is covered by the trailer instead,
+// as checked by Verify. All integers are little endian uint32s, except for
+// document ids which are uint64s.
+//
+//	header:  magic[8] version nodeCount entryCount labelCount
+//	nodes:   nodeCount records of
+//	         char firstChild childCount entryOffset entryCount labelOffset labelCount
+//	entries: entryCount document ids
+//	labels:  labelCount runes
+//	scores:  records of document id and float64 score bits, in id order
+//	trailer: CRC-32 (IEEE) of everything from the magic to the scores
+//
+// Nodes are written breadth first with the root at index 0, so the children
+// of any node are contiguous and, like in the heap trie, sorted by char.
+var mappedMagic = []byte("TRIEMAP\x00")
+
+const (
+	mappedVersion = 3
+	// version 2 files, with uint32 document ids, can still be read
+	mappedVersion32  = 2
+	mappedHeaderSize = 24
+	mappedNodeSize   = 28
+)
+
+// MarshalMapped writes the trie in the flat format read by OpenMapped.
+func (t *Trie) MarshalMapped(w io.Writer) error {
+	nodes := []*trie_pb.Node{t.root}
+	entries := [][]uint64{}
+	entryCount := 0
+	labelCount := 0
+	for i := 0; i < len(nodes); i++ {
+		nodes = append(nodes, nodes[i].Children...)
+		entries = append(entries, nodeEntries(nodes[i]))
+		entryCount += len(entries[i])
+		labelCount += len(nodes[i].Label)
+	}
+
+	scores := t.mappedScores()
+	header, err := marshalHeader(t.meta, crc32.ChecksumIEEE(scores), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return errors.Wrap(err, "could not write")
+	}
+
+	// the body is streamed, and checksummed on the way out
+	sum := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, sum))
+	buf := make([]byte, mappedNodeSize)
+
+	bw.Write(mappedMagic)
+	binary.LittleEndian.PutUint32(buf[0:], mappedVersion)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(nodes)))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(entryCount))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(labelCount))
+	bw.Write(buf[0:16])
+
+	nextChild := uint32(1)
+	entryOffset := uint32(0)
+	labelOffset := uint32(0)
+	for i, n := range nodes {
+		binary.LittleEndian.PutUint32(buf[0:], n.Char)
+		binary.LittleEndian.PutUint32(buf[4:], nextChild)
+		binary.LittleEndian.PutUint32(buf[8:], uint32(len(n.Children)))
+		binary.LittleEndian.PutUint32(buf[12:], entryOffset)
+		binary.LittleEndian.PutUint32(buf[16:], uint32(len(entries[i])))
+		binary.LittleEndian.PutUint32(buf[20:], labelOffset)
+		binary.LittleEndian.PutUint32(buf[24:], uint32(len(n.Label)))
+		bw.Write(buf)
+		nextChild += uint32(len(n.Children))
+		entryOffset += uint32(len(entries[i]))
+		labelOffset += uint32(len(n.Label))
+	}
+
+	for _, es := range entries {
+		for _, e := range es {
+			binary.LittleEndian.PutUint64(buf[0:], e)
+			bw.Write(buf[0:8])
+		}
+	}
+	for _, n := range nodes {
+		for _, l := range n.Label {
+			binary.LittleEndian.PutUint32(buf[0:], l)
+			bw.Write(buf[0:4])
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "could not write")
+	}
+
+	sum.Write(scores)
+	trailer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(trailer, sum.Sum32())
+	if _, err := w.Write(scores); err != nil {
+		return errors.Wrap(err, "could not write")
+	}
+	_, err = w.Write(trailer)
+	return errors.Wrap(err, "could not write")
+}
+
+// mappedScores returns the scores section of the mapped format.
+func (t *Trie) mappedScores() []byte {
+	ids := make([]uint64, 0, len(t.scores))
+	for id := range t.scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	buf := make([]byte, len(ids)*16)
+	for i, id := range ids {
+		binary.LittleEndian.PutUint64(buf[i*16:], id)
+		binary.LittleEndian.PutUint64(buf[i*16+8:], math.Float64bits(t.scores[id]))
+	}
+	return buf
+}
+
+// Mapped is a read only trie searched directly in its flat serialised form,
+// usually backed by a memory mapped file.
+type Mapped struct {
+	nodes   []byte
+	entries []byte
+	// entryWidth is the size in bytes of each document id
+	entryWidth uint64
+	labels     []byte
+	nodeCount  uint32
+	meta       Metadata
+	// scores are records of an id and a score, and body is everything the
+	// trailer, if there is one, is the checksum of
+	scores  []byte
+	body    []byte
+	trailer []byte
+	unmap   func() error
+}
+
+// OpenMapped memory maps a file written by MarshalMapped, checking its
+// header against expected as Unmarshal does. Only the header and scores are
+// read, so the body is only known to be intact once Verify has been called.
+// The file stays mapped until Close is called.
+func OpenMapped(path string, expected Metadata) (*Mapped, error) {
+	data, unmap, err := mmapFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not map trie")
+	}
+	m, err := NewMapped(data, expected)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	m.unmap = unmap
+	return m, nil
+}
+
+// NewMapped reads a trie from data in the format written by MarshalMapped.
+// data is referenced, not copied. Files written before the format had a
+// header are only read if nothing is expected of them.
+func NewMapped(data []byte, expected Metadata) (*Mapped, error) {
+	var h *trie_pb.Header
+	var meta Metadata
+	if hasHeader(data) || expected != (Metadata{}) {
+		var err error
+		h, meta, data, err = readHeader(data, expected)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) < mappedHeaderSize || !bytes.Equal(data[0:8], mappedMagic) {
+		return nil, errors.New("not a mapped trie")
+	}
+	version := binary.LittleEndian.Uint32(data[8:])
+	var entryWidth uint64
+	switch version {
+	case mappedVersion:
+		entryWidth = 8
+	case mappedVersion32:
+		entryWidth = 4
+	default:
+		return nil, errors.Errorf("unsupported mapped trie version %v", version)
+	}
+	nodeCount := binary.LittleEndian.Uint32(data[12:])
+	entryCount := binary.LittleEndian.Uint32(data[16:])
+	labelCount := binary.LittleEndian.Uint32(data[20:])
+
+	nodesEnd := mappedHeaderSize + uint64(nodeCount)*mappedNodeSize
+	entriesEnd := nodesEnd + uint64(entryCount)*entryWidth
+	labelsEnd := entriesEnd + uint64(labelCount)*4
+	if nodeCount == 0 || uint64(len(data)) < labelsEnd {
+		return nil, errors.New("mapped trie is truncated or corrupt")
+	}
+	m := &Mapped{
+		nodes:      data[mappedHeaderSize:nodesEnd],
+		entries:    data[nodesEnd:entriesEnd],
+		entryWidth: entryWidth,
+		labels:     data[entriesEnd:labelsEnd],
+		nodeCount:  nodeCount,
+		meta:       meta,
+	}
+
+	// files without a header have no scores or trailer either
+	rest := data[labelsEnd:]
+	if h == nil {
+		if len(rest) != 0 {
+			return nil, errors.New("mapped trie is truncated or corrupt")
+		}
+		return m, nil
+	}
+	if len(rest) < 4 || (len(rest)-4)%m.scoreSize() != 0 {
+		return nil, errors.New("mapped trie is truncated or corrupt")
+	}
+	m.scores = rest[:len(rest)-4]
+	m.body = data[:len(data)-4]
+	m.trailer = rest[len(rest)-4:]
+	if sum := crc32.ChecksumIEEE(m.scores); sum != h.Checksum {
+		return nil, errors.Errorf("trie file is corrupt, checksum %08x of scores doesn't match header's %08x", sum, h.Checksum)
+	}
+	return m, nil
+}
+
+// Verify checks the whole of the trie against the checksum in its trailer,
+// which means reading every page of a mapped file.
+func (m *Mapped) Verify() error {
+	if m.trailer == nil {
+		return nil
+	}
+	expected := binary.LittleEndian.Uint32(m.trailer)
+	if sum := crc32.ChecksumIEEE(m.body); sum != expected {
+		return errors.Errorf("trie file is corrupt, checksum %08x doesn't match trailer's %08x", sum, expected)
+	}
+	return nil
+}
+
+// Metadata returns the metadata in the trie's header.
+func (m *Mapped) Metadata() Metadata {
+	return m.meta
+}
+
+// Score returns the score the trie's buckets ranked entry by, searching the
+// scores section in place.
+func (m *Mapped) Score(entry uint64) float64 {
+	size := m.scoreSize()
+	count := len(m.scores) / size
+	ix := sort.Search(count, func(i int) bool {
+		return m.id(m.scores[i*size:]) >= entry
+	})
+	if ix == count || m.id(m.scores[ix*size:]) != entry {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(m.scores[ix*size+int(m.entryWidth):]))
+}
+
+// scoreSize is the size in bytes of each record of the scores section.
+func (m *Mapped) scoreSize() int {
+	return int(m.entryWidth) + 8
+}
+
+// id reads a document id from the start of b.
+func (m *Mapped) id(b []byte) uint64 {
+	if m.entryWidth == 4 {
+		return uint64(binary.LittleEndian.Uint32(b))
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// Close unmaps the underlying file, after which the trie must not be used.
+func (m *Mapped) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.unmap()
+	m.unmap = nil
+	return errors.Wrap(err, "could not unmap trie")
+}
+
+func (m *Mapped) Lookup(value []rune) []uint64 {
+	n := uint32(0)
+	for i := 0; i < len(value); {
+		child, ok := m.findChild(n, value[i])
+		if !ok {
+			return nil
+		}
+		i++
+
+		rec := m.node(child)
+		offset := uint64(binary.LittleEndian.Uint32(rec[20:]))
+		count := uint64(binary.LittleEndian.Uint32(rec[24:]))
+		if (offset+count)*4 > uint64(len(m.labels)) {
+			return nil
+		}
+		for j := uint64(0); j < count && i < len(value); j++ {
+			if binary.LittleEndian.Uint32(m.labels[(offset+j)*4:]) != uint32(value[i]) {
+				return nil
+			}
+			i++
+		}
+		n = child
+	}
+
+	rec := m.node(n)
+	offset := binary.LittleEndian.Uint32(rec[12:])
+	count := binary.LittleEndian.Uint32(rec[16:])
+	if (uint64(offset)+uint64(count))*m.entryWidth > uint64(len(m.entries)) {
+		return nil
+	}
+	res := make([]uint64, count)
+	for i := range res {
+		res[i] = m.id(m.entries[(uint64(offset)+uint64(i))*m.entryWidth:])
+	}
+	return res
+}
+
+func (m *Mapped) node(n uint32) []byte {
+	start := uint64(n) * mappedNodeSize
+	return m.nodes[start : start+mappedNodeSize]
+}
+
+func (m *Mapped) findChild(n uint32, c rune) (uint32, bool) {
+	rec := m.node(n)
+	first := binary.LittleEndian.Uint32(rec[4:])
+	count := binary.LittleEndian.Uint32(rec[8:])
+	if uint64(first)+uint64(count) > uint64(m.nodeCount) {
+		return 0, false
+	}
+	ix := sort.Search(int(count), func(i int) bool {
+		return binary.LittleEndian.Uint32(m.node(first+uint32(i))) >= uint32(c)
+	})
+	if ix < int(count) && binary.LittleEndian.Uint32(m.node(first+uint32(ix))) == uint32(c) {
+		return first + uint32(ix), true
+	}
+	return 0, false
+}
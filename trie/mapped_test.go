@@ -0,0 +1,148 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMappedLookup(t *testing.T) {
+	t.Parallel()
+
+	tr, lexemes := benchCatalogue(2000)
+	tr.Compress()
+	tr.SetScore(7, 2.5)
+	meta := Metadata{MaxLexemeLength: 10, Parser: "bench", DocumentCount: 2000}
+	tr.SetMetadata(meta)
+	buf := &bytes.Buffer{}
+	if err := tr.MarshalMapped(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "mapped")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trie.map")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write trie: %v", err)
+	}
+
+	if _, err := OpenMapped(path, Metadata{Parser: "other"}); err == nil {
+		t.Errorf("expected a trie built with another parser to be rejected")
+	}
+	m, err := OpenMapped(path, Metadata{MaxLexemeLength: 10, Parser: "bench"})
+	if err != nil {
+		t.Fatalf("could not open mapped trie: %v", err)
+	}
+	defer m.Close()
+	if got := m.Metadata(); !reflect.DeepEqual(got, meta) {
+		t.Errorf("unexpected metadata\nGot: %+v\nExpected: %+v", got, meta)
+	}
+	if got := m.Score(7); got != 2.5 {
+		t.Errorf("expected score 2.5, got %v", got)
+	}
+
+	queries := append([][]rune{[]rune(""), []rune("zzzzzzzzzzzz")}, allPrefixes(lexemes)...)
+	for _, q := range queries {
+		expected := tr.Lookup(q)
+		got := m.Lookup(q)
+		if len(expected) == 0 && len(got) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("unexpected lookup of %q\nGot: %v\nExpected: %v", string(q), got, expected)
+		}
+	}
+}
+
+func TestNewMappedRejectsCorrupt(t *testing.T) {
+	t.Parallel()
+
+	tr, _ := benchCatalogue(10)
+	tr.SetScore(3, 1)
+	buf := &bytes.Buffer{}
+	if err := tr.MarshalMapped(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	corruptScores := append([]byte{}, data...)
+	corruptScores[len(corruptScores)-5] ^= 0xff
+	cases := [][]byte{
+		nil,
+		[]byte("not a trie at all"),
+		data[:len(data)-1],
+		corruptScores,
+	}
+	for _, c := range cases {
+		if _, err := NewMapped(c, Metadata{}); err == nil {
+			t.Errorf("expected error for %d bytes", len(c))
+		}
+	}
+
+	// the body is only read in full by Verify
+	corruptBody := append([]byte{}, data...)
+	corruptBody[len(corruptBody)-30] ^= 0xff
+	m, err := NewMapped(corruptBody, Metadata{})
+	if err != nil {
+		t.Fatalf("could not read mapped trie: %v", err)
+	}
+	if err := m.Verify(); err == nil {
+		t.Errorf("expected a corrupt body to fail verification")
+	}
+	m, err = NewMapped(data, Metadata{})
+	if err != nil {
+		t.Fatalf("could not read mapped trie: %v", err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
+
+func TestNewMappedReadsVersion2(t *testing.T) {
+	t.Parallel()
+
+	tr, lexemes := benchCatalogue(200)
+	tr.Compress()
+	buf := &bytes.Buffer{}
+	if err := tr.MarshalMapped(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	// rewrite the entries area with the uint32 ids of version 2, which
+	// had no header, scores or trailer
+	_, _, data, err := readHeader(buf.Bytes(), Metadata{})
+	if err != nil {
+		t.Fatalf("could not read header: %v", err)
+	}
+	data = data[:len(data)-4]
+	nodeCount := binary.LittleEndian.Uint32(data[12:])
+	entryCount := binary.LittleEndian.Uint32(data[16:])
+	nodesEnd := mappedHeaderSize + int(nodeCount)*mappedNodeSize
+	entriesEnd := nodesEnd + int(entryCount)*8
+	old := append([]byte{}, data[:nodesEnd]...)
+	binary.LittleEndian.PutUint32(old[8:], mappedVersion32)
+	for i := nodesEnd; i < entriesEnd; i += 8 {
+		old = append(old, data[i:i+4]...)
+	}
+	old = append(old, data[entriesEnd:]...)
+
+	if _, err := NewMapped(old, Metadata{Parser: "bench"}); err == nil {
+		t.Errorf("expected a trie without a header to be rejected when metadata is expected")
+	}
+	m, err := NewMapped(old, Metadata{})
+	if err != nil {
+		t.Fatalf("could not read version 2 trie: %v", err)
+	}
+	for _, q := range allPrefixes(lexemes) {
+		if got, expected := m.Lookup(q), tr.Lookup(q); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("unexpected lookup of %q\nGot: %v\nExpected: %v", string(q), got, expected)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+// +build !unix
+
+package trie
+
+import "io/ioutil"
+
+// mmapFile falls back to reading the whole file where mmap isn't available.
+func mmapFile(path string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
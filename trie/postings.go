@@ -0,0 +1,130 @@
+package trie
+
+import (
+	"encoding/binary"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+)
+
+// EncodePostings packs a sorted posting list as the gaps between successive
+// entries, each a uvarint.
+func EncodePostings(entries []uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*len(entries))
+	n := 0
+	last := uint64(0)
+	for _, e := range entries {
+		n += binary.PutUvarint(buf[n:], e-last)
+		last = e
+	}
+	return buf[:n]
+}
+
+// DecodePostings unpacks a posting list written by EncodePostings.
+func DecodePostings(data []byte) []uint64 {
+	res := []uint64{}
+	it := NewPostingIterator(data)
+	for {
+		e, ok := it.Next()
+		if !ok {
+			return res
+		}
+		res = append(res, e)
+	}
+}
+
+// PostingIterator decodes a packed posting list one entry at a time.
+type PostingIterator struct {
+	data []byte
+	last uint64
+}
+
+func NewPostingIterator(data []byte) *PostingIterator {
+	return &PostingIterator{data: data}
+}
+
+// Next returns the next entry, or false once the list is exhausted.
+func (it *PostingIterator) Next() (uint64, bool) {
+	if len(it.data) == 0 {
+		return 0, false
+	}
+	gap, n := binary.Uvarint(it.data)
+	if n <= 0 {
+		it.data = nil
+		return 0, false
+	}
+	it.data = it.data[n:]
+	it.last += gap
+	return it.last, true
+}
+
+// PackPostings replaces every node's TopEntries with their packed form,
+// which is smaller in memory and on disk. Lookups decode them as needed, and
+// anything that changes the trie unpacks them again first.
+func (t *Trie) PackPostings() {
+	t.iterateLRN(t.root, func(n *trie_pb.Node) {
+		if len(n.TopEntries) == 0 {
+			return
+		}
+		n.PackedEntries = EncodePostings(n.TopEntries)
+		n.TopEntries = nil
+	})
+	t.packed = true
+}
+
+func (t *Trie) unpackPostings() {
+	if !t.packed {
+		return
+	}
+	t.iterateLRN(t.root, func(n *trie_pb.Node) {
+		if len(n.PackedEntries) == 0 {
+			return
+		}
+		n.TopEntries = DecodePostings(n.PackedEntries)
+		n.PackedEntries = nil
+	})
+	t.packed = false
+}
+
+// nodeEntries returns the entries of n in whichever form they are held.
+func nodeEntries(n *trie_pb.Node) []uint64 {
+	if len(n.PackedEntries) != 0 {
+		return DecodePostings(n.PackedEntries)
+	}
+	return n.TopEntries
+}
+
+// SaturatedPostings calls fn with the full posting list of every prefix
+// that has more than maxEntries entries, all of which MergeUpwards would
+// otherwise truncate away. It must be called before MergeUpwards.
+func (t *Trie) SaturatedPostings(maxEntries int, fn func(prefix []rune, entries []uint64) error) error {
+	t.unpackPostings()
+	_, err := saturatedPostings(t.root, nil, maxEntries, fn)
+	return err
+}
+
+func saturatedPostings(n *trie_pb.Node, prefix []rune, maxEntries int, fn func([]rune, []uint64) error) ([]uint64, error) {
+	// appended entries are ascending but may repeat
+	entries := make([]uint64, 0, len(n.TopEntries))
+	for i, e := range n.TopEntries {
+		if i == 0 || e != n.TopEntries[i-1] {
+			entries = append(entries, e)
+		}
+	}
+	for _, child := range n.Children {
+		childPrefix := append(prefix, rune(child.Char))
+		for _, l := range child.Label {
+			childPrefix = append(childPrefix, rune(l))
+		}
+		childEntries, err := saturatedPostings(child, childPrefix, maxEntries, fn)
+		if err != nil {
+			return nil, err
+		}
+		entries = mergeEntries(entries, childEntries)
+	}
+	if len(prefix) != 0 && len(entries) > maxEntries {
+		if err := fn(prefix, entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,100 @@
+package trie
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodePostings(t *testing.T) {
+	t.Parallel()
+
+	cases := [][]uint64{
+		{},
+		{0},
+		{1, 2, 3, 4},
+		{5, 300, 70000, 1 << 31, 1<<32 - 1},
+		{1 << 40, 1<<64 - 1},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			got := DecodePostings(EncodePostings(c))
+			if !reflect.DeepEqual(got, c) {
+				t.Errorf("unexpected result\nGot: %v\nExpected: %v", got, c)
+			}
+		})
+	}
+}
+
+func TestPackPostings(t *testing.T) {
+	t.Parallel()
+
+	tr, lexemes := benchCatalogue(20000)
+	tr.Compress()
+	plain := &bytes.Buffer{}
+	if err := tr.Marshal(plain); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	tr.PackPostings()
+	packed := &bytes.Buffer{}
+	if err := tr.Marshal(packed); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if packed.Len() >= plain.Len() {
+		t.Errorf("expected packed trie to be smaller, got %v bytes against %v", packed.Len(), plain.Len())
+	}
+
+	fromPlain := NewTrie()
+	if err := fromPlain.Unmarshal(plain); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	fromPacked := NewTrie()
+	if err := fromPacked.Unmarshal(packed); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	for _, p := range allPrefixes(lexemes) {
+		expected := fromPlain.Lookup(p)
+		if got := fromPacked.Lookup(p); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("unexpected lookup of %q\nGot: %v\nExpected: %v", string(p), got, expected)
+		}
+	}
+
+	fromPacked.Append([]rune("zzzzzzzzzzzz"), 5000)
+	fromPacked.MergeUpwards(64)
+	if got, expected := fromPacked.Lookup([]rune("zzzzzzzzzzzz")), []uint64{5000}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected lookup after append\nGot: %v\nExpected: %v", got, expected)
+	}
+	if got, expected := fromPacked.Lookup(lexemes[0]), fromPlain.Lookup(lexemes[0]); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected lookup after append\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func TestSaturatedPostings(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.Append([]rune("sa"), 1)
+	tr.Append([]rune("sb"), 2)
+	tr.Append([]rune("sb"), 2)
+	tr.Append([]rune("sc"), 3)
+	tr.Append([]rune("tshirt"), 3)
+	tr.Append([]rune("sd"), 4)
+
+	got := map[string][]uint64{}
+	err := tr.SaturatedPostings(2, func(prefix []rune, entries []uint64) error {
+		got[string(prefix)] = append([]uint64{}, entries...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]uint64{"s": {1, 2, 3, 4}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected result\nGot: %v\nExpected: %v", got, expected)
+	}
+}
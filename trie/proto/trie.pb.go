@@ -10,6 +10,7 @@
 
 	It has these top-level messages:
 		Node
+		Header
 */
 package trie
 
@@ -31,9 +32,13 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type Node struct {
-	Char       uint32   `protobuf:"varint,1,opt,name=char,proto3" json:"char,omitempty"`
-	TopEntries []uint32 `protobuf:"varint,2,rep,packed,name=topEntries" json:"topEntries,omitempty"`
-	Children   []*Node  `protobuf:"bytes,3,rep,name=children" json:"children,omitempty"`
+	Char          uint32   `protobuf:"varint,1,opt,name=char,proto3" json:"char,omitempty"`
+	TopEntries    []uint64 `protobuf:"varint,2,rep,packed,name=topEntries" json:"topEntries,omitempty"`
+	Children      []*Node  `protobuf:"bytes,3,rep,name=children" json:"children,omitempty"`
+	Label         []uint32 `protobuf:"varint,4,rep,packed,name=label" json:"label,omitempty"`
+	Terminal      bool     `protobuf:"varint,5,opt,name=terminal,proto3" json:"terminal,omitempty"`
+	DocumentCount uint32   `protobuf:"varint,6,opt,name=documentCount,proto3" json:"documentCount,omitempty"`
+	PackedEntries []byte   `protobuf:"bytes,7,opt,name=packedEntries,proto3" json:"packedEntries,omitempty"`
 }
 
 func (m *Node) Reset()                    { *m = Node{} }
@@ -48,7 +53,7 @@ func (m *Node) GetChar() uint32 {
 	return 0
 }
 
-func (m *Node) GetTopEntries() []uint32 {
+func (m *Node) GetTopEntries() []uint64 {
 	if m != nil {
 		return m.TopEntries
 	}
@@ -62,8 +67,125 @@ func (m *Node) GetChildren() []*Node {
 	return nil
 }
 
+func (m *Node) GetLabel() []uint32 {
+	if m != nil {
+		return m.Label
+	}
+	return nil
+}
+
+func (m *Node) GetTerminal() bool {
+	if m != nil {
+		return m.Terminal
+	}
+	return false
+}
+
+func (m *Node) GetDocumentCount() uint32 {
+	if m != nil {
+		return m.DocumentCount
+	}
+	return 0
+}
+
+func (m *Node) GetPackedEntries() []byte {
+	if m != nil {
+		return m.PackedEntries
+	}
+	return nil
+}
+
+type Header struct {
+	Version         uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	MaxLexemeLength uint32 `protobuf:"varint,2,opt,name=maxLexemeLength,proto3" json:"maxLexemeLength,omitempty"`
+	MaxBucketLength uint32 `protobuf:"varint,3,opt,name=maxBucketLength,proto3" json:"maxBucketLength,omitempty"`
+	Parser          string `protobuf:"bytes,4,opt,name=parser,proto3" json:"parser,omitempty"`
+	DocumentCount   uint64 `protobuf:"varint,5,opt,name=documentCount,proto3" json:"documentCount,omitempty"`
+	BuiltAt         int64  `protobuf:"varint,6,opt,name=builtAt,proto3" json:"builtAt,omitempty"`
+	Checksum        uint32 `protobuf:"varint,7,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	Scores          []byte `protobuf:"bytes,8,opt,name=scores,proto3" json:"scores,omitempty"`
+	IndexSuffixes   bool   `protobuf:"varint,9,opt,name=indexSuffixes,proto3" json:"indexSuffixes,omitempty"`
+	MinSuffixLength uint32 `protobuf:"varint,10,opt,name=minSuffixLength,proto3" json:"minSuffixLength,omitempty"`
+}
+
+func (m *Header) Reset()                    { *m = Header{} }
+func (m *Header) String() string            { return proto.CompactTextString(m) }
+func (*Header) ProtoMessage()               {}
+func (*Header) Descriptor() ([]byte, []int) { return fileDescriptorTrie, []int{1} }
+
+func (m *Header) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *Header) GetMaxLexemeLength() uint32 {
+	if m != nil {
+		return m.MaxLexemeLength
+	}
+	return 0
+}
+
+func (m *Header) GetMaxBucketLength() uint32 {
+	if m != nil {
+		return m.MaxBucketLength
+	}
+	return 0
+}
+
+func (m *Header) GetParser() string {
+	if m != nil {
+		return m.Parser
+	}
+	return ""
+}
+
+func (m *Header) GetDocumentCount() uint64 {
+	if m != nil {
+		return m.DocumentCount
+	}
+	return 0
+}
+
+func (m *Header) GetBuiltAt() int64 {
+	if m != nil {
+		return m.BuiltAt
+	}
+	return 0
+}
+
+func (m *Header) GetChecksum() uint32 {
+	if m != nil {
+		return m.Checksum
+	}
+	return 0
+}
+
+func (m *Header) GetScores() []byte {
+	if m != nil {
+		return m.Scores
+	}
+	return nil
+}
+
+func (m *Header) GetIndexSuffixes() bool {
+	if m != nil {
+		return m.IndexSuffixes
+	}
+	return false
+}
+
+func (m *Header) GetMinSuffixLength() uint32 {
+	if m != nil {
+		return m.MinSuffixLength
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Node)(nil), "Node")
+	proto.RegisterType((*Header)(nil), "Header")
 }
 func (m *Node) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
@@ -114,6 +236,119 @@ func (m *Node) MarshalTo(dAtA []byte) (int, error) {
 			i += n
 		}
 	}
+	if len(m.Label) > 0 {
+		dAtA4 := make([]byte, len(m.Label)*10)
+		var j3 int
+		for _, num := range m.Label {
+			for num >= 1<<7 {
+				dAtA4[j3] = uint8(uint64(num)&0x7f | 0x80)
+				num >>= 7
+				j3++
+			}
+			dAtA4[j3] = uint8(num)
+			j3++
+		}
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(j3))
+		i += copy(dAtA[i:], dAtA4[:j3])
+	}
+	if m.Terminal {
+		dAtA[i] = 0x28
+		i++
+		if m.Terminal {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.DocumentCount != 0 {
+		dAtA[i] = 0x30
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.DocumentCount))
+	}
+	if len(m.PackedEntries) > 0 {
+		dAtA[i] = 0x3a
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(len(m.PackedEntries)))
+		i += copy(dAtA[i:], m.PackedEntries)
+	}
+	return i, nil
+}
+
+func (m *Header) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Header) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Version != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.Version))
+	}
+	if m.MaxLexemeLength != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.MaxLexemeLength))
+	}
+	if m.MaxBucketLength != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.MaxBucketLength))
+	}
+	if len(m.Parser) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(len(m.Parser)))
+		i += copy(dAtA[i:], m.Parser)
+	}
+	if m.DocumentCount != 0 {
+		dAtA[i] = 0x28
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.DocumentCount))
+	}
+	if m.BuiltAt != 0 {
+		dAtA[i] = 0x30
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.BuiltAt))
+	}
+	if m.Checksum != 0 {
+		dAtA[i] = 0x38
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.Checksum))
+	}
+	if len(m.Scores) > 0 {
+		dAtA[i] = 0x42
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(len(m.Scores)))
+		i += copy(dAtA[i:], m.Scores)
+	}
+	if m.IndexSuffixes {
+		dAtA[i] = 0x48
+		i++
+		if m.IndexSuffixes {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.MinSuffixLength != 0 {
+		dAtA[i] = 0x50
+		i++
+		i = encodeVarintTrie(dAtA, i, uint64(m.MinSuffixLength))
+	}
 	return i, nil
 }
 
@@ -163,6 +398,61 @@ func (m *Node) Size() (n int) {
 			n += 1 + l + sovTrie(uint64(l))
 		}
 	}
+	if len(m.Label) > 0 {
+		l = 0
+		for _, e := range m.Label {
+			l += sovTrie(uint64(e))
+		}
+		n += 1 + sovTrie(uint64(l)) + l
+	}
+	if m.Terminal {
+		n += 2
+	}
+	if m.DocumentCount != 0 {
+		n += 1 + sovTrie(uint64(m.DocumentCount))
+	}
+	l = len(m.PackedEntries)
+	if l > 0 {
+		n += 1 + l + sovTrie(uint64(l))
+	}
+	return n
+}
+
+func (m *Header) Size() (n int) {
+	var l int
+	_ = l
+	if m.Version != 0 {
+		n += 1 + sovTrie(uint64(m.Version))
+	}
+	if m.MaxLexemeLength != 0 {
+		n += 1 + sovTrie(uint64(m.MaxLexemeLength))
+	}
+	if m.MaxBucketLength != 0 {
+		n += 1 + sovTrie(uint64(m.MaxBucketLength))
+	}
+	l = len(m.Parser)
+	if l > 0 {
+		n += 1 + l + sovTrie(uint64(l))
+	}
+	if m.DocumentCount != 0 {
+		n += 1 + sovTrie(uint64(m.DocumentCount))
+	}
+	if m.BuiltAt != 0 {
+		n += 1 + sovTrie(uint64(m.BuiltAt))
+	}
+	if m.Checksum != 0 {
+		n += 1 + sovTrie(uint64(m.Checksum))
+	}
+	l = len(m.Scores)
+	if l > 0 {
+		n += 1 + l + sovTrie(uint64(l))
+	}
+	if m.IndexSuffixes {
+		n += 2
+	}
+	if m.MinSuffixLength != 0 {
+		n += 1 + sovTrie(uint64(m.MinSuffixLength))
+	}
 	return n
 }
 
@@ -229,7 +519,7 @@ func (m *Node) Unmarshal(dAtA []byte) error {
 			}
 		case 2:
 			if wireType == 0 {
-				var v uint32
+				var v uint64
 				for shift := uint(0); ; shift += 7 {
 					if shift >= 64 {
 						return ErrIntOverflowTrie
@@ -239,7 +529,7 @@ func (m *Node) Unmarshal(dAtA []byte) error {
 					}
 					b := dAtA[iNdEx]
 					iNdEx++
-					v |= (uint32(b) & 0x7F) << shift
+					v |= (uint64(b) & 0x7F) << shift
 					if b < 0x80 {
 						break
 					}
@@ -269,7 +559,7 @@ func (m *Node) Unmarshal(dAtA []byte) error {
 					return io.ErrUnexpectedEOF
 				}
 				for iNdEx < postIndex {
-					var v uint32
+					var v uint64
 					for shift := uint(0); ; shift += 7 {
 						if shift >= 64 {
 							return ErrIntOverflowTrie
@@ -279,7 +569,7 @@ func (m *Node) Unmarshal(dAtA []byte) error {
 						}
 						b := dAtA[iNdEx]
 						iNdEx++
-						v |= (uint32(b) & 0x7F) << shift
+						v |= (uint64(b) & 0x7F) << shift
 						if b < 0x80 {
 							break
 						}
@@ -320,6 +610,401 @@ func (m *Node) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 4:
+			if wireType == 0 {
+				var v uint32
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowTrie
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= (uint32(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				m.Label = append(m.Label, v)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowTrie
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= (int(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthTrie
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				for iNdEx < postIndex {
+					var v uint32
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowTrie
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= (uint32(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.Label = append(m.Label, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Label", wireType)
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Terminal", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Terminal = bool(v != 0)
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DocumentCount", wireType)
+			}
+			m.DocumentCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.DocumentCount |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PackedEntries", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthTrie
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PackedEntries = append(m.PackedEntries[:0], dAtA[iNdEx:postIndex]...)
+			if m.PackedEntries == nil {
+				m.PackedEntries = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipTrie(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthTrie
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Header) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowTrie
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Header: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Header: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			m.Version = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Version |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxLexemeLength", wireType)
+			}
+			m.MaxLexemeLength = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxLexemeLength |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxBucketLength", wireType)
+			}
+			m.MaxBucketLength = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxBucketLength |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Parser", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthTrie
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Parser = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DocumentCount", wireType)
+			}
+			m.DocumentCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.DocumentCount |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field BuiltAt", wireType)
+			}
+			m.BuiltAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.BuiltAt |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Checksum", wireType)
+			}
+			m.Checksum = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Checksum |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Scores", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthTrie
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Scores = append(m.Scores[:0], dAtA[iNdEx:postIndex]...)
+			if m.Scores == nil {
+				m.Scores = []byte{}
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IndexSuffixes", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.IndexSuffixes = bool(v != 0)
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinSuffixLength", wireType)
+			}
+			m.MinSuffixLength = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTrie
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinSuffixLength |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipTrie(dAtA[iNdEx:])
@@ -449,14 +1134,27 @@ var (
 func init() { proto.RegisterFile("trie/proto/trie.proto", fileDescriptorTrie) }
 
 var fileDescriptorTrie = []byte{
-	// 137 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x2d, 0x29, 0xca, 0x4c,
-	0xd5, 0x2f, 0x28, 0xca, 0x2f, 0xc9, 0xd7, 0x07, 0x31, 0xf5, 0xc0, 0x4c, 0xa5, 0x58, 0x2e, 0x16,
-	0xbf, 0xfc, 0x94, 0x54, 0x21, 0x21, 0x2e, 0x96, 0xe4, 0x8c, 0xc4, 0x22, 0x09, 0x46, 0x05, 0x46,
-	0x0d, 0xde, 0x20, 0x30, 0x5b, 0x48, 0x8e, 0x8b, 0xab, 0x24, 0xbf, 0xc0, 0x35, 0x0f, 0xa4, 0xbc,
-	0x58, 0x82, 0x49, 0x81, 0x59, 0x83, 0x37, 0x08, 0x49, 0x44, 0x48, 0x91, 0x8b, 0x23, 0x39, 0x23,
-	0x33, 0x27, 0xa5, 0x28, 0x35, 0x4f, 0x82, 0x59, 0x81, 0x59, 0x83, 0xdb, 0x88, 0x55, 0x0f, 0x64,
-	0x58, 0x10, 0x5c, 0xd8, 0x49, 0xe0, 0xc4, 0x23, 0x39, 0xc6, 0x0b, 0x8f, 0xe4, 0x18, 0x1f, 0x3c,
-	0x92, 0x63, 0x9c, 0xf1, 0x58, 0x8e, 0x21, 0x89, 0x0d, 0x6c, 0xaf, 0x31, 0x20, 0x00, 0x00, 0xff,
-	0xff, 0x67, 0xd2, 0xad, 0x3f, 0x90, 0x00, 0x00, 0x00,
+	// 338 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x92, 0xc1, 0x6a, 0xe3, 0x30,
+	0x10, 0x86, 0x71, 0xec, 0x38, 0xce, 0xec, 0x9a, 0x05, 0xb1, 0xbb, 0x88, 0x1e, 0x8a, 0x1b, 0x7a,
+	0xd0, 0x29, 0x81, 0xf6, 0x09, 0xda, 0x52, 0xe8, 0x21, 0xf4, 0xa0, 0x3e, 0x81, 0x23, 0x4f, 0x6a,
+	0x11, 0x5b, 0x32, 0xb2, 0x5c, 0xfc, 0x9c, 0xbd, 0xf6, 0x65, 0x8a, 0x14, 0xc7, 0xc4, 0xe9, 0x6d,
+	0xfe, 0x8f, 0x1f, 0x6b, 0xbe, 0xc1, 0xf0, 0xcf, 0x1a, 0x89, 0x9b, 0xc6, 0x68, 0xab, 0x37, 0x6e,
+	0x5c, 0xfb, 0x71, 0xf5, 0x15, 0x40, 0xf4, 0xaa, 0x0b, 0x24, 0x04, 0x22, 0x51, 0xe6, 0x86, 0x06,
+	0x59, 0xc0, 0x52, 0xee, 0x67, 0x72, 0x0d, 0x60, 0x75, 0xf3, 0xac, 0x5c, 0xbf, 0xa5, 0xb3, 0x2c,
+	0x64, 0x11, 0x3f, 0x23, 0xe4, 0x06, 0x12, 0x51, 0xca, 0xaa, 0x30, 0xa8, 0x68, 0x98, 0x85, 0xec,
+	0xd7, 0xdd, 0x7c, 0xed, 0x3e, 0xc6, 0x47, 0x4c, 0xfe, 0xc2, 0xbc, 0xca, 0x77, 0x58, 0xd1, 0x28,
+	0x0b, 0x59, 0xca, 0x8f, 0x81, 0x5c, 0x41, 0x62, 0xd1, 0xd4, 0x52, 0xe5, 0x15, 0x9d, 0x67, 0x01,
+	0x4b, 0xf8, 0x98, 0xc9, 0x2d, 0xa4, 0x85, 0x16, 0x5d, 0x8d, 0xca, 0x3e, 0xe9, 0x4e, 0x59, 0x1a,
+	0xfb, 0x8d, 0xa6, 0xd0, 0xb5, 0x9a, 0x5c, 0x1c, 0xb0, 0x38, 0x6d, 0xb7, 0xc8, 0x02, 0xf6, 0x9b,
+	0x4f, 0xe1, 0xea, 0x73, 0x06, 0xf1, 0x0b, 0xe6, 0x05, 0x1a, 0x42, 0x61, 0xf1, 0x81, 0xa6, 0x95,
+	0x5a, 0x0d, 0x8a, 0xa7, 0x48, 0x18, 0xfc, 0xa9, 0xf3, 0x7e, 0x8b, 0x3d, 0xd6, 0xb8, 0x45, 0xf5,
+	0x6e, 0x4b, 0x3a, 0xf3, 0x8d, 0x4b, 0x3c, 0x34, 0x1f, 0x3b, 0x71, 0x40, 0x3b, 0x34, 0xc3, 0xb1,
+	0x79, 0x8e, 0xc9, 0x7f, 0x88, 0x9b, 0xdc, 0xb4, 0x68, 0x68, 0x94, 0x05, 0x6c, 0xc9, 0x87, 0xf4,
+	0x53, 0xce, 0xd9, 0x47, 0x97, 0x72, 0x14, 0x16, 0xbb, 0x4e, 0x56, 0xf6, 0xe1, 0x28, 0x1f, 0xf2,
+	0x53, 0x74, 0x87, 0x13, 0x25, 0x8a, 0x43, 0xdb, 0xd5, 0xde, 0x38, 0xe5, 0x63, 0x76, 0x6f, 0xb6,
+	0x42, 0x1b, 0x6c, 0x69, 0xe2, 0x6f, 0x31, 0x24, 0xf7, 0xa6, 0x54, 0x05, 0xf6, 0x6f, 0xdd, 0x7e,
+	0x2f, 0x7b, 0x6c, 0xe9, 0xd2, 0x5f, 0x7c, 0x0a, 0xbd, 0x9b, 0x54, 0xc7, 0x38, 0xb8, 0xc1, 0xe0,
+	0x36, 0xc5, 0xbb, 0xd8, 0xff, 0x39, 0xf7, 0xdf, 0x03, 0x00, 0xe2, 0x1c, 0x3f, 0x29, 0x52, 0x02,
+	0x00, 0x00,
 }
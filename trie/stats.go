@@ -0,0 +1,83 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+)
+
+// Stats describes the shape of a trie, for tuning the lexeme and bucket
+// lengths it is built with. Depths are counted in runes from the root, so
+// a node at the end of a compressed label is as deep as the prefix it holds.
+type Stats struct {
+	Nodes         int
+	TerminalNodes int
+	// DepthNodes holds the number of nodes at each depth.
+	DepthNodes []int
+	// FanOut holds the mean number of children of the nodes at each depth.
+	FanOut []float64
+	// Entries is the number of entries across every node's bucket, and
+	// SaturatedBuckets the number of buckets holding MaxBucketLength of
+	// them.
+	Entries          int
+	SaturatedBuckets int
+	MaxBucketLength  int
+	// PostingBytes is the memory held by posting lists, packed or not.
+	PostingBytes int
+}
+
+// Stats walks the trie and reports on its shape.
+func (t *Trie) Stats() Stats {
+	s := Stats{MaxBucketLength: t.maxEntries}
+	children := []int{}
+	var walk func(n *trie_pb.Node, depth int)
+	walk = func(n *trie_pb.Node, depth int) {
+		depth += len(n.Label)
+		for len(s.DepthNodes) <= depth {
+			s.DepthNodes = append(s.DepthNodes, 0)
+			children = append(children, 0)
+		}
+		s.Nodes++
+		s.DepthNodes[depth]++
+		children[depth] += len(n.Children)
+		if n.Terminal {
+			s.TerminalNodes++
+		}
+
+		entries := len(n.TopEntries)
+		if len(n.PackedEntries) != 0 {
+			entries = len(DecodePostings(n.PackedEntries))
+		}
+		s.Entries += entries
+		s.PostingBytes += 4*len(n.TopEntries) + len(n.PackedEntries)
+		if t.maxEntries > 0 && entries >= t.maxEntries {
+			s.SaturatedBuckets++
+		}
+
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(t.root, 0)
+
+	s.FanOut = make([]float64, len(children))
+	for d, c := range children {
+		if s.DepthNodes[d] != 0 {
+			s.FanOut[d] = float64(c) / float64(s.DepthNodes[d])
+		}
+	}
+	return s
+}
+
+func (s Stats) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "nodes: %v (%v terminal)\n", s.Nodes, s.TerminalNodes)
+	fmt.Fprintf(buf, "entries: %v in %v bytes\n", s.Entries, s.PostingBytes)
+	fmt.Fprintf(buf, "saturated buckets: %v at length %v\n", s.SaturatedBuckets, s.MaxBucketLength)
+	fmt.Fprintf(buf, "depth\tnodes\tfan out\n")
+	for d := range s.DepthNodes {
+		fmt.Fprintf(buf, "%v\t%v\t%.2f\n", d, s.DepthNodes[d], s.FanOut[d])
+	}
+	return buf.String()
+}
@@ -0,0 +1,31 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.Append([]rune("ab"), 1)
+	tr.Append([]rune("ac"), 2)
+	tr.Append([]rune("bcd"), 3)
+	tr.MergeUpwards(2)
+	tr.Compress()
+
+	expected := Stats{
+		Nodes:            5,
+		TerminalNodes:    3,
+		DepthNodes:       []int{1, 1, 2, 1},
+		FanOut:           []float64{2, 2, 0, 0},
+		Entries:          7,
+		SaturatedBuckets: 2,
+		MaxBucketLength:  2,
+		PostingBytes:     28,
+	}
+	if got := tr.Stats(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected result\nGot: %#v\nExpected: %#v", got, expected)
+	}
+}
@@ -0,0 +1,114 @@
+package trie
+
+import (
+	"sort"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+	"github.com/pkg/errors"
+)
+
+// StreamBuilder fills an empty trie from values given in ascending order,
+// finishing each node's bucket as soon as the last value below it has gone
+// by. It leaves the trie as Append followed by MergeUpwards would, but only
+// ever holds the untruncated entries of the nodes on the current path.
+type StreamBuilder struct {
+	t          *Trie
+	maxEntries int
+	frames     []streamFrame
+	last       []rune
+	started    bool
+}
+
+// streamFrame is a node on the current path, along with the entries added
+// to it directly.
+type streamFrame struct {
+	node    *trie_pb.Node
+	entries []uint64
+	counted bool
+}
+
+// NewStreamBuilder starts building into t, which must be empty, truncating
+// buckets to maxEntries.
+func (t *Trie) NewStreamBuilder(maxEntries int) *StreamBuilder {
+	t.maxEntries = maxEntries
+	t.meta.MaxBucketLength = maxEntries
+	return &StreamBuilder{
+		t:          t,
+		maxEntries: maxEntries,
+		frames:     []streamFrame{{node: t.root}},
+	}
+}
+
+// Add adds entry under value, as Append does if terminal is set or as
+// AppendSuffix does otherwise. Values must come in ascending order, and the
+// entries of any one value in ascending order too.
+func (b *StreamBuilder) Add(value []rune, entry uint64, terminal bool) error {
+	common := 0
+	for common < len(value) && common < len(b.last) && value[common] == b.last[common] {
+		common++
+	}
+	if b.started && common < len(value) && common < len(b.last) && value[common] < b.last[common] ||
+		b.started && common == len(value) && len(value) < len(b.last) {
+		return errors.Errorf("%q added after %q", string(value), string(b.last))
+	}
+	b.started = true
+
+	if common != len(b.last) || len(value) != len(b.last) {
+		b.close(common + 1)
+		for _, c := range value[common:] {
+			child := &trie_pb.Node{
+				Char:       uint32(c),
+				TopEntries: []uint64{},
+				Children:   []*trie_pb.Node{},
+			}
+			parent := b.frames[len(b.frames)-1].node
+			parent.Children = append(parent.Children, child)
+			b.frames = append(b.frames, streamFrame{node: child})
+		}
+		b.last = append(b.last[:0], value...)
+	}
+
+	f := &b.frames[len(b.frames)-1]
+	if k := len(f.entries); k != 0 && f.entries[k-1] > entry {
+		return errors.Errorf("entry %v added to %q after %v", entry, string(value), f.entries[k-1])
+	}
+	if k := len(f.entries); k == 0 || f.entries[k-1] != entry {
+		f.entries = append(f.entries, entry)
+		f.counted = false
+	}
+	if terminal {
+		f.node.Terminal = true
+		if !f.counted {
+			f.node.DocumentCount++
+			f.counted = true
+		}
+	}
+	return nil
+}
+
+// Finish completes the buckets of every node still open.
+func (b *StreamBuilder) Finish() {
+	b.close(0)
+}
+
+// close finishes the nodes on the path deeper than depth, deepest first.
+func (b *StreamBuilder) close(depth int) {
+	for len(b.frames) > depth {
+		f := b.frames[len(b.frames)-1]
+		entries := f.entries
+		for _, c := range f.node.Children {
+			entries = mergeEntries(entries, c.TopEntries)
+		}
+		if b.maxEntries > 0 && len(entries) > b.maxEntries {
+			sort.Slice(entries, func(i, j int) bool {
+				return b.t.better(entries[i], entries[j])
+			})
+			entries = entries[:b.maxEntries]
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i] < entries[j]
+			})
+		}
+		f.node.TopEntries = entries
+		b.frames = b.frames[:len(b.frames)-1]
+	}
+}
@@ -0,0 +1,34 @@
+package trie
+
+import (
+	"testing"
+)
+
+func TestStreamBuilderRejectsUnsortedValues(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		values  []string
+		entries []uint64
+		ok      bool
+	}{
+		{values: []string{"a", "ab", "b"}, entries: []uint64{1, 1, 1}, ok: true},
+		{values: []string{"ab", "ab"}, entries: []uint64{1, 2}, ok: true},
+		{values: []string{"ab", "a"}, entries: []uint64{1, 1}},
+		{values: []string{"b", "ab"}, entries: []uint64{1, 1}},
+		{values: []string{"ab", "ab"}, entries: []uint64{2, 1}},
+	}
+
+	for _, tc := range tests {
+		b := NewTrie().NewStreamBuilder(10)
+		var err error
+		for i, v := range tc.values {
+			if err = b.Add([]rune(v), tc.entries[i], true); err != nil {
+				break
+			}
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("unexpected result adding %v: %v", tc.values, err)
+		}
+	}
+}
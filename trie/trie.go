@@ -13,7 +13,11 @@ import (
 )
 
 type Trie struct {
-	root *trie_pb.Node
+	root       *trie_pb.Node
+	maxEntries int
+	scores     map[uint64]float64
+	packed     bool
+	meta       Metadata
 }
 
 func NewTrie() *Trie {
@@ -29,7 +33,23 @@ func (t *Trie) Unmarshal(r io.Reader) error {
 	if err != nil {
 		return errors.Wrap(err, "could not read")
 	}
-	return proto.Unmarshal(bytes, t.root)
+	body, err := t.decodeHeader(bytes)
+	if err != nil {
+		return err
+	}
+	err = proto.Unmarshal(body, t.root)
+	if err != nil {
+		return errors.Wrap(err, "could not unmarshal")
+	}
+	// files written before children were kept sorted need sorting once
+	// so that findChild can binary search them
+	t.iterateLRN(t.root, func(n *trie_pb.Node) {
+		sortChildren(n)
+		if len(n.PackedEntries) != 0 {
+			t.packed = true
+		}
+	})
+	return nil
 }
 
 func (t *Trie) Marshal(buf io.Writer) error {
@@ -37,6 +57,14 @@ func (t *Trie) Marshal(buf io.Writer) error {
 	if err != nil {
 		return errors.Wrap(err, "could not unmarshal")
 	}
+	header, err := t.encodeHeader(bytes)
+	if err != nil {
+		return err
+	}
+	_, err = buf.Write(header)
+	if err != nil {
+		return errors.Wrap(err, "could not write")
+	}
 	_, err = buf.Write(bytes)
 	return errors.Wrap(err, "could not write")
 }
@@ -49,85 +77,182 @@ func (t *Trie) String() string {
 	return fmt.Sprintf("children: %s", string(childChars))
 }
 
-func (t *Trie) Lookup(value []rune) []uint32 {
+func (t *Trie) Lookup(value []rune) []uint64 {
 	n := t.root
-	for i := 0; i < len(value); i++ {
+	for i := 0; i < len(value); {
 		child := findChild(n, value[i])
 		if child == nil {
 			return nil
 		}
+		var j int
+		i, j = matchLabel(child, value, i+1)
+		if j < len(child.Label) && i < len(value) {
+			return nil
+		}
 		n = child
 	}
-	return n.TopEntries
+	return nodeEntries(n)
 }
 
 func (t *Trie) lookupOrInsert(value []rune) *trie_pb.Node {
+	t.unpackPostings()
 	n := t.root
-	for i := 0; i < len(value); i++ {
+	for i := 0; i < len(value); {
 		child := findChild(n, value[i])
-		if child != nil {
-			n = child
-		} else {
+		if child == nil {
 			child = &trie_pb.Node{
 				Char:       uint32(value[i]),
-				TopEntries: []uint32{},
+				TopEntries: []uint64{},
 				Children:   []*trie_pb.Node{},
 			}
-			n.Children = append(n.Children, child)
+			insertChild(n, child)
 			n = child
+			i++
+			continue
 		}
+		var j int
+		i, j = matchLabel(child, value, i+1)
+		if j < len(child.Label) {
+			splitLabel(child, j)
+		}
+		n = child
 	}
 	return n
 }
 
-func (t *Trie) Insert(value []rune, topEntries []uint32) {
+func (t *Trie) Insert(value []rune, topEntries []uint64) {
 	n := t.lookupOrInsert(value)
 	n.TopEntries = topEntries
+	n.Terminal = true
+	n.DocumentCount = uint32(len(topEntries))
 }
 
-func (t *Trie) Append(value []rune, entry uint32) {
+// Append adds entry to the node for value, marking it as the end of a whole
+// lexeme. Entries are cheapest to add in ascending order, but may come in
+// any; repeats of a lexeme within one document are counted once.
+func (t *Trie) Append(value []rune, entry uint64) {
 	n := t.lookupOrInsert(value)
-	n.TopEntries = append(n.TopEntries, entry)
+	n.Terminal = true
+	if appendEntry(n, entry) {
+		n.DocumentCount++
+	}
+}
+
+// AppendSuffix adds entry to the node for value, a suffix of some lexeme,
+// without marking it as a whole lexeme. Suffixes can be looked up to match
+// within lexemes, but aren't offered as completions.
+func (t *Trie) AppendSuffix(value []rune, entry uint64) {
+	appendEntry(t.lookupOrInsert(value), entry)
 }
 
+// appendEntry adds entry to n's sorted entries, reporting whether it wasn't
+// already there.
+func appendEntry(n *trie_pb.Node, entry uint64) bool {
+	if k := len(n.TopEntries); k == 0 || n.TopEntries[k-1] < entry {
+		n.TopEntries = append(n.TopEntries, entry)
+		return true
+	}
+	if hasEntry(n.TopEntries, entry) {
+		return false
+	}
+	n.TopEntries = insertEntry(n.TopEntries, entry)
+	return true
+}
+
+// MergeUpwards fills every node's TopEntries from its children, keeping at
+// most maxEntries per node. Where there are more, the entries with the
+// highest scores are kept, falling back to the lowest entries when scores
+// are equal.
 func (t *Trie) MergeUpwards(maxEntries int) {
+	t.maxEntries = maxEntries
+	t.meta.MaxBucketLength = maxEntries
+	t.unpackPostings()
 	t.iterateLRN(t.root, func(e *trie_pb.Node) {
-		if len(e.TopEntries) > maxEntries {
-			e.TopEntries = e.TopEntries[0:maxEntries]
-		}
-		if len(e.Children) == 0 {
-			return
-		}
-		maxChildEntries := 0
 		glog.V(4).Infof("%v children", len(e.Children))
-		for _, child := range e.Children {
-			if len(child.TopEntries) > maxChildEntries {
-				maxChildEntries = len(child.TopEntries)
+
+		// entries already held, such as those copied onto either side of a
+		// split label, must not be merged in twice
+		seen := make(map[uint64]bool, len(e.TopEntries))
+		entries := make([]uint64, 0, len(e.TopEntries))
+		for _, entry := range e.TopEntries {
+			if !seen[entry] {
+				seen[entry] = true
+				entries = append(entries, entry)
 			}
-			glog.V(4).Infof("c: %v", child.TopEntries)
 		}
-
-	loop:
-		// j is our position up the list of topEntries for each child
-		for j := 0; j < maxChildEntries; j++ {
-			for _, c := range e.Children {
-				if len(c.TopEntries) <= j {
-					continue
-				}
-				if len(e.TopEntries) >= maxEntries {
-					break loop
+		for _, c := range e.Children {
+			glog.V(4).Infof("c: %v", c.TopEntries)
+			for _, entry := range c.TopEntries {
+				if !seen[entry] {
+					seen[entry] = true
+					entries = append(entries, entry)
 				}
-
-				e.TopEntries = append(e.TopEntries, c.TopEntries[j])
 			}
 		}
-		sort.Slice(e.TopEntries, func(i, j int) bool {
-			return e.TopEntries[i] < e.TopEntries[j]
+
+		if len(entries) > maxEntries {
+			sort.Slice(entries, func(i, j int) bool {
+				return t.better(entries[i], entries[j])
+			})
+			entries = entries[0:maxEntries]
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i] < entries[j]
 		})
+		e.TopEntries = entries
 		glog.V(2).Infof("res: %v", e.TopEntries)
 	})
 }
 
+// SetScore sets the score MergeUpwards ranks entry by. Entries without a
+// score have a score of zero.
+func (t *Trie) SetScore(entry uint64, score float64) {
+	if score == 0 {
+		delete(t.scores, entry)
+		return
+	}
+	if t.scores == nil {
+		t.scores = map[uint64]float64{}
+	}
+	t.scores[entry] = score
+}
+
+// Score returns the score set for entry.
+func (t *Trie) Score(entry uint64) float64 {
+	return t.scores[entry]
+}
+
+// better reports whether entry a should be kept over entry b when a bucket
+// is full.
+func (t *Trie) better(a, b uint64) bool {
+	sa, sb := t.scores[a], t.scores[b]
+	if sa != sb {
+		return sa > sb
+	}
+	return a < b
+}
+
+// Compress collapses chains of single children that hold the same
+// TopEntries as their parent into one node, keeping the extra runes in its
+// Label. Nodes ending a whole lexeme are kept so they can still be told
+// apart. Entries are only final once MergeUpwards has run, so Compress
+// should follow it.
+func (t *Trie) Compress() {
+	t.unpackPostings()
+	t.iterateLRN(t.root, func(n *trie_pb.Node) {
+		if n == t.root {
+			return
+		}
+		for !n.Terminal && len(n.Children) == 1 && entriesEqual(n.TopEntries, n.Children[0].TopEntries) {
+			c := n.Children[0]
+			n.Label = append(append(n.Label, c.Char), c.Label...)
+			n.Children = c.Children
+			n.Terminal = c.Terminal
+			n.DocumentCount = c.DocumentCount
+		}
+	})
+}
+
 func (t *Trie) iterateLRN(n *trie_pb.Node, cb func(*trie_pb.Node)) {
 	for _, child := range n.Children {
 		t.iterateLRN(child, cb)
@@ -135,11 +260,77 @@ func (t *Trie) iterateLRN(n *trie_pb.Node, cb func(*trie_pb.Node)) {
 	cb(n)
 }
 
-func findChild(node *trie_pb.Node, c rune) *trie_pb.Node {
-	for _, child := range node.Children {
-		if child.Char == uint32(c) {
-			return child
+// matchLabel matches value, from position i, against the label of n. It
+// returns the new position in value and how many label runes matched.
+func matchLabel(n *trie_pb.Node, value []rune, i int) (int, int) {
+	j := 0
+	for j < len(n.Label) && i < len(value) && n.Label[j] == uint32(value[i]) {
+		i++
+		j++
+	}
+	return i, j
+}
+
+// splitLabel breaks the edge into n after j runes of its label, moving the
+// rest of the label and n's children onto a new single child.
+func splitLabel(n *trie_pb.Node, j int) {
+	lower := &trie_pb.Node{
+		Char:          n.Label[j],
+		Label:         append([]uint32(nil), n.Label[j+1:]...),
+		TopEntries:    n.TopEntries,
+		Children:      n.Children,
+		Terminal:      n.Terminal,
+		DocumentCount: n.DocumentCount,
+	}
+	n.TopEntries = append([]uint64{}, n.TopEntries...)
+	n.Label = append([]uint32(nil), n.Label[:j]...)
+	n.Children = []*trie_pb.Node{lower}
+	n.Terminal = false
+	n.DocumentCount = 0
+}
+
+func entriesEqual(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
 	}
+	return true
+}
+
+// findChild binary searches the children of node, which are kept sorted
+// by Char.
+func findChild(node *trie_pb.Node, c rune) *trie_pb.Node {
+	ix := childIndex(node, c)
+	if ix < len(node.Children) && node.Children[ix].Char == uint32(c) {
+		return node.Children[ix]
+	}
 	return nil
 }
+
+func childIndex(node *trie_pb.Node, c rune) int {
+	return sort.Search(len(node.Children), func(i int) bool {
+		return node.Children[i].Char >= uint32(c)
+	})
+}
+
+func insertChild(node *trie_pb.Node, child *trie_pb.Node) {
+	ix := childIndex(node, rune(child.Char))
+	node.Children = append(node.Children, nil)
+	copy(node.Children[ix+1:], node.Children[ix:])
+	node.Children[ix] = child
+}
+
+func sortChildren(node *trie_pb.Node) {
+	if sort.SliceIsSorted(node.Children, func(i, j int) bool {
+		return node.Children[i].Char < node.Children[j].Char
+	}) {
+		return
+	}
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Char < node.Children[j].Char
+	})
+}
@@ -0,0 +1,285 @@
+package trie
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+)
+
+func TestChildrenSorted(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		values   []string
+		expected string
+	}{
+		{
+			values:   []string{"zip", "art", "mop", "bat"},
+			expected: "abmz",
+		},
+		{
+			values:   []string{"9", "1", "x", "a", "5"},
+			expected: "159ax",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			tr := NewTrie()
+			for i, v := range c.values {
+				tr.Append([]rune(v), uint64(i))
+			}
+			if got := childChars(tr.root); got != c.expected {
+				t.Errorf("unexpected children\nGot: %v\nExpected: %v", got, c.expected)
+			}
+
+			buf := &bytes.Buffer{}
+			if err := tr.Marshal(buf); err != nil {
+				t.Fatalf("marshal failed: %v", err)
+			}
+			loaded := NewTrie()
+			if err := loaded.Unmarshal(buf); err != nil {
+				t.Fatalf("unmarshal failed: %v", err)
+			}
+			if got := childChars(loaded.root); got != c.expected {
+				t.Errorf("unexpected children after unmarshal\nGot: %v\nExpected: %v", got, c.expected)
+			}
+			for i, v := range c.values {
+				if got := loaded.Lookup([]rune(v)); !reflect.DeepEqual(got, []uint64{uint64(i)}) {
+					t.Errorf("unexpected lookup of %v\nGot: %v\nExpected: %v", v, got, []uint64{uint64(i)})
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalSortsLegacyChildren(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.root.Children = []*trie_pb.Node{
+		{Char: 'c', TopEntries: []uint64{3}},
+		{Char: 'a', TopEntries: []uint64{1}},
+		{Char: 'b', TopEntries: []uint64{2}},
+	}
+	buf := &bytes.Buffer{}
+	if err := tr.Marshal(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	loaded := NewTrie()
+	if err := loaded.Unmarshal(buf); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if got := childChars(loaded.root); got != "abc" {
+		t.Errorf("unexpected children\nGot: %v\nExpected: %v", got, "abc")
+	}
+	if got := loaded.Lookup([]rune("b")); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("unexpected lookup\nGot: %v\nExpected: %v", got, []uint64{2})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	tr, lexemes := benchCatalogue(2000)
+	prefixes := allPrefixes(lexemes)
+	expected := make([][]uint64, len(prefixes))
+	for i, p := range prefixes {
+		expected[i] = tr.Lookup(p)
+	}
+	before := countNodes(tr.root)
+
+	tr.Compress()
+	if after := countNodes(tr.root); after >= before {
+		t.Errorf("expected fewer nodes after compression, had %v now %v", before, after)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tr.Marshal(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	loaded := NewTrie()
+	if err := loaded.Unmarshal(buf); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	for i, p := range prefixes {
+		if got := tr.Lookup(p); !reflect.DeepEqual(got, expected[i]) {
+			t.Fatalf("unexpected lookup of %q\nGot: %v\nExpected: %v", string(p), got, expected[i])
+		}
+		if got := loaded.Lookup(p); !reflect.DeepEqual(got, expected[i]) {
+			t.Fatalf("unexpected lookup of %q after unmarshal\nGot: %v\nExpected: %v", string(p), got, expected[i])
+		}
+	}
+	if got := tr.Lookup([]rune("not-a-lexeme")); got != nil {
+		t.Errorf("unexpected lookup of missing lexeme\nGot: %v", got)
+	}
+}
+
+func TestAppendSplitsLabels(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.Append([]rune("shirt"), 1)
+	tr.MergeUpwards(10)
+	tr.Compress()
+	if got := countNodes(tr.root); got != 2 {
+		t.Fatalf("expected a single edge, got %v nodes", got)
+	}
+
+	tr.Append([]rune("shoe"), 2)
+	tr.Append([]rune("sh"), 3)
+	tr.MergeUpwards(10)
+
+	cases := []struct {
+		value    string
+		expected []uint64
+	}{
+		{value: "s", expected: []uint64{1, 2, 3}},
+		{value: "sh", expected: []uint64{1, 2, 3}},
+		{value: "shi", expected: []uint64{1}},
+		{value: "shirt", expected: []uint64{1}},
+		{value: "sho", expected: []uint64{2}},
+		{value: "shoe", expected: []uint64{2}},
+		{value: "shy", expected: nil},
+	}
+	for _, c := range cases {
+		if got := tr.Lookup([]rune(c.value)); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("unexpected lookup of %v\nGot: %v\nExpected: %v", c.value, got, c.expected)
+		}
+	}
+}
+
+func TestMergeUpwardsByScore(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	for i, v := range []string{"sa", "sb", "sc", "sd", "se"} {
+		tr.Append([]rune(v), uint64(i))
+	}
+	tr.SetScore(3, 10)
+	tr.SetScore(4, 5)
+	tr.SetScore(1, 1)
+	tr.MergeUpwards(3)
+
+	if got, expected := tr.Lookup([]rune("s")), []uint64{1, 3, 4}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected lookup\nGot: %v\nExpected: %v", got, expected)
+	}
+
+	unscored := NewTrie()
+	for i, v := range []string{"sa", "sb", "sc", "sd", "se"} {
+		unscored.Append([]rune(v), uint64(4-i))
+	}
+	unscored.MergeUpwards(3)
+	if got, expected := unscored.Lookup([]rune("s")), []uint64{0, 1, 2}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected lookup without scores\nGot: %v\nExpected: %v", got, expected)
+	}
+
+	// the scores have to survive a round trip for documents added to a
+	// loaded trie to be ranked against those already in it
+	tr.SetMetadata(Metadata{MaxBucketLength: 3})
+	buf := &bytes.Buffer{}
+	if err := tr.Marshal(buf); err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	tr = NewTrie()
+	if err := tr.Unmarshal(buf); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	tr.SetScore(7, 20)
+	tr.AddDocument([][]rune{[]rune("sf")}, 7)
+	if got, expected := tr.Lookup([]rune("s")), []uint64{3, 4, 7}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected lookup after adding\nGot: %v\nExpected: %v", got, expected)
+	}
+	tr.RemoveDocument([][]rune{[]rune("sd")}, 3)
+	if got, expected := tr.Lookup([]rune("s")), []uint64{1, 4, 7}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected lookup after removing\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func allPrefixes(lexemes [][]rune) [][]rune {
+	res := [][]rune{}
+	for _, l := range lexemes {
+		for i := 1; i <= len(l); i++ {
+			res = append(res, l[:i])
+		}
+	}
+	return res
+}
+
+func countNodes(n *trie_pb.Node) int {
+	c := 1
+	for _, child := range n.Children {
+		c += countNodes(child)
+	}
+	return c
+}
+
+func childChars(n *trie_pb.Node) string {
+	chars := make([]rune, len(n.Children))
+	for i, c := range n.Children {
+		chars[i] = rune(c.Char)
+	}
+	return string(chars)
+}
+
+const benchAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func benchCatalogue(n int) (*Trie, [][]rune) {
+	rnd := rand.New(rand.NewSource(1))
+	tr := NewTrie()
+	lexemes := make([][]rune, n)
+	for i := range lexemes {
+		l := make([]rune, 3+rnd.Intn(8))
+		for j := range l {
+			l[j] = rune(benchAlphabet[rnd.Intn(len(benchAlphabet))])
+		}
+		lexemes[i] = l
+		tr.Append(l, uint64(i))
+	}
+	tr.MergeUpwards(64)
+	return tr, lexemes
+}
+
+// lookupLinear is the pre-sorted-children lookup, kept to benchmark against.
+func lookupLinear(t *Trie, value []rune) []uint64 {
+	n := t.root
+	for i := 0; i < len(value); i++ {
+		var next *trie_pb.Node
+		for _, child := range n.Children {
+			if child.Char == uint32(value[i]) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n.TopEntries
+}
+
+func BenchmarkLookup(b *testing.B) {
+	tr, lexemes := benchCatalogue(200000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.Lookup(lexemes[i%len(lexemes)])
+	}
+}
+
+func BenchmarkLookupLinear(b *testing.B) {
+	tr, lexemes := benchCatalogue(200000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lookupLinear(tr, lexemes[i%len(lexemes)])
+	}
+}
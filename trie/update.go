@@ -0,0 +1,243 @@
+package trie
+
+import (
+	"sort"
+
+	trie_pb "github.com/QubitProducts/triesbien/trie/proto"
+	"github.com/pkg/errors"
+)
+
+// AddDocument indexes id under each of lexemes in a trie that has already
+// been through MergeUpwards. id is added to every node along each path that
+// still has room in its bucket, or that holds an entry it beats on score, so
+// ancestors stay as MergeUpwards would have left them.
+func (t *Trie) AddDocument(lexemes [][]rune, id uint64) {
+	for _, lexeme := range uniqueLexemes(lexemes) {
+		t.addPath(lexeme, id, true)
+	}
+}
+
+// AddSuffixes is AddDocument for the suffixes of a document's lexemes, as
+// added by AppendSuffix. It should follow AddDocument for the same id.
+func (t *Trie) AddSuffixes(suffixes [][]rune, id uint64) {
+	for _, suffix := range uniqueLexemes(suffixes) {
+		t.addPath(suffix, id, false)
+	}
+}
+
+func (t *Trie) addPath(lexeme []rune, id uint64, terminal bool) {
+	leaf := t.lookupOrInsert(lexeme)
+	if terminal {
+		leaf.Terminal = true
+		if !hasEntry(leaf.TopEntries, id) {
+			leaf.DocumentCount++
+		}
+	}
+	path, _ := t.path(lexeme)
+	for _, n := range path {
+		if hasEntry(n.TopEntries, id) {
+			continue
+		}
+		if t.maxEntries > 0 && len(n.TopEntries) >= t.maxEntries {
+			worst := t.worst(n.TopEntries)
+			if !t.better(id, worst) {
+				continue
+			}
+			n.TopEntries, _ = removeEntry(n.TopEntries, worst)
+		}
+		n.TopEntries = insertEntry(n.TopEntries, id)
+	}
+}
+
+// RemoveDocument removes id from every node on the paths of lexemes.
+// Ancestors whose buckets were full are topped back up from their children,
+// and nodes left with nothing in them are pruned.
+func (t *Trie) RemoveDocument(lexemes [][]rune, id uint64) {
+	t.unpackPostings()
+	for _, lexeme := range uniqueLexemes(lexemes) {
+		t.removePath(lexeme, id, true)
+	}
+	delete(t.scores, id)
+}
+
+// RemoveSuffixes is RemoveDocument for the suffixes of a document's
+// lexemes.
+func (t *Trie) RemoveSuffixes(suffixes [][]rune, id uint64) {
+	t.unpackPostings()
+	for _, suffix := range uniqueLexemes(suffixes) {
+		t.removePath(suffix, id, false)
+	}
+}
+
+func (t *Trie) removePath(lexeme []rune, id uint64, terminal bool) {
+	path, found := t.path(lexeme)
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+		full := t.maxEntries > 0 && len(n.TopEntries) >= t.maxEntries
+		var removed bool
+		n.TopEntries, removed = removeEntry(n.TopEntries, id)
+		// the document is only counted off a lexeme it was indexed under,
+		// which a full bucket may not have had room to show
+		held := removed || full
+		if i == len(path)-1 && terminal && found && held && n.Terminal && n.DocumentCount > 0 {
+			n.DocumentCount--
+			n.Terminal = n.DocumentCount > 0
+		}
+		if removed && full {
+			t.refill(n)
+		}
+		if i > 0 && len(n.TopEntries) == 0 && len(n.Children) == 0 {
+			removeChild(path[i-1], n)
+		}
+	}
+}
+
+// SetMaxEntries sets the bucket length used by AddDocument and
+// RemoveDocument. MergeUpwards sets it, and Unmarshal restores it from the
+// file header, so this is rarely needed.
+func (t *Trie) SetMaxEntries(maxEntries int) {
+	t.maxEntries = maxEntries
+}
+
+// path returns the nodes from the root down to the one holding value, and
+// whether value ends exactly at the last of them. If value isn't in the trie
+// the nodes of its longest stored prefix are returned.
+func (t *Trie) path(value []rune) ([]*trie_pb.Node, bool) {
+	n := t.root
+	res := []*trie_pb.Node{n}
+	for i := 0; i < len(value); {
+		child := findChild(n, value[i])
+		if child == nil {
+			return res, false
+		}
+		var j int
+		i, j = matchLabel(child, value, i+1)
+		if j < len(child.Label) && i < len(value) {
+			return res, false
+		}
+		res = append(res, child)
+		if j < len(child.Label) {
+			return res, false
+		}
+		n = child
+	}
+	return res, true
+}
+
+// refill tops a bucket back up with the best of its children's entries.
+func (t *Trie) refill(n *trie_pb.Node) {
+	candidates := []uint64{}
+	for _, c := range n.Children {
+		for _, entry := range c.TopEntries {
+			if !hasEntry(n.TopEntries, entry) {
+				candidates = append(candidates, entry)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return t.better(candidates[i], candidates[j])
+	})
+	for _, entry := range candidates {
+		if len(n.TopEntries) >= t.maxEntries {
+			return
+		}
+		n.TopEntries = insertEntry(n.TopEntries, entry)
+	}
+}
+
+// worst returns the entry that would be dropped first from entries.
+func (t *Trie) worst(entries []uint64) uint64 {
+	w := entries[0]
+	for _, entry := range entries[1:] {
+		if t.better(w, entry) {
+			w = entry
+		}
+	}
+	return w
+}
+
+func uniqueLexemes(lexemes [][]rune) [][]rune {
+	seen := make(map[string]bool, len(lexemes))
+	res := make([][]rune, 0, len(lexemes))
+	for _, l := range lexemes {
+		if seen[string(l)] {
+			continue
+		}
+		seen[string(l)] = true
+		res = append(res, l)
+	}
+	return res
+}
+
+func hasEntry(entries []uint64, entry uint64) bool {
+	ix := sort.Search(len(entries), func(i int) bool {
+		return entries[i] >= entry
+	})
+	return ix < len(entries) && entries[ix] == entry
+}
+
+func insertEntry(entries []uint64, entry uint64) []uint64 {
+	ix := sort.Search(len(entries), func(i int) bool {
+		return entries[i] >= entry
+	})
+	if ix < len(entries) && entries[ix] == entry {
+		return entries
+	}
+	entries = append(entries, 0)
+	copy(entries[ix+1:], entries[ix:])
+	entries[ix] = entry
+	return entries
+}
+
+func removeEntry(entries []uint64, entry uint64) ([]uint64, bool) {
+	ix := sort.Search(len(entries), func(i int) bool {
+		return entries[i] >= entry
+	})
+	if ix == len(entries) || entries[ix] != entry {
+		return entries, false
+	}
+	return append(entries[:ix], entries[ix+1:]...), true
+}
+
+func removeChild(node *trie_pb.Node, child *trie_pb.Node) {
+	ix := childIndex(node, rune(child.Char))
+	if ix < len(node.Children) && node.Children[ix] == child {
+		node.Children = append(node.Children[:ix], node.Children[ix+1:]...)
+	}
+}
+
+// Merge moves the contents of other into the trie, so that tries built from
+// separate shards of a catalogue can be combined. Both tries must still be
+// as Append left them: Merge comes before MergeUpwards and Compress.
+// other must not be used afterwards.
+func (t *Trie) Merge(other *Trie) error {
+	t.unpackPostings()
+	other.unpackPostings()
+	if err := mergeNode(t.root, other.root); err != nil {
+		return err
+	}
+	for entry, score := range other.scores {
+		t.SetScore(entry, score)
+	}
+	return nil
+}
+
+func mergeNode(a, b *trie_pb.Node) error {
+	if len(a.Label) != 0 || len(b.Label) != 0 {
+		return errors.New("cannot merge compressed tries")
+	}
+	a.TopEntries = mergeEntries(a.TopEntries, b.TopEntries)
+	a.Terminal = a.Terminal || b.Terminal
+	a.DocumentCount += b.DocumentCount
+	for _, bc := range b.Children {
+		ac := findChild(a, rune(bc.Char))
+		if ac == nil {
+			insertChild(a, bc)
+			continue
+		}
+		if err := mergeNode(ac, bc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
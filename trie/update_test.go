@@ -0,0 +1,70 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddRemoveDocument(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.Append([]rune("shirt"), 1)
+	tr.Append([]rune("shoe"), 2)
+	tr.Append([]rune("shorts"), 3)
+	tr.MergeUpwards(2)
+	tr.Compress()
+
+	tr.RemoveDocument([][]rune{[]rune("shirt")}, 1)
+	tr.AddDocument([][]rune{[]rune("shirt"), []rune("sharp")}, 4)
+
+	cases := []struct {
+		value    string
+		expected []uint64
+	}{
+		{value: "", expected: []uint64{2, 3}},
+		{value: "s", expected: []uint64{2, 3}},
+		{value: "sh", expected: []uint64{2, 3}},
+		{value: "sha", expected: []uint64{4}},
+		{value: "shi", expected: []uint64{4}},
+		{value: "shirt", expected: []uint64{4}},
+		{value: "sho", expected: []uint64{2, 3}},
+		{value: "shor", expected: []uint64{3}},
+	}
+	for _, c := range cases {
+		if got := tr.Lookup([]rune(c.value)); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("unexpected lookup of %q\nGot: %v\nExpected: %v", c.value, got, c.expected)
+		}
+	}
+
+	tr.RemoveDocument([][]rune{[]rune("shirt"), []rune("sharp")}, 4)
+	if got := tr.Lookup([]rune("shi")); got != nil {
+		t.Errorf("expected removed lexeme to be pruned\nGot: %v", got)
+	}
+	if got := tr.Lookup([]rune("sha")); got != nil {
+		t.Errorf("expected removed lexeme to be pruned\nGot: %v", got)
+	}
+}
+
+func TestRemoveDocumentTwice(t *testing.T) {
+	t.Parallel()
+
+	tr := NewTrie()
+	tr.Append([]rune("shoe"), 1)
+	tr.Append([]rune("shoe"), 2)
+	tr.Append([]rune("shirt"), 3)
+	tr.MergeUpwards(10)
+	tr.Compress()
+
+	// removing a document again, or under lexemes it never had, leaves
+	// the documents that do have them counted
+	tr.RemoveDocument([][]rune{[]rune("shoe")}, 1)
+	tr.RemoveDocument([][]rune{[]rune("shoe")}, 1)
+	tr.RemoveDocument([][]rune{[]rune("shoe"), []rune("shirt")}, 3)
+	tr.RemoveDocument([][]rune{[]rune("shirt")}, 2)
+
+	expected := []Completion{{Lexeme: "shoe", Documents: 1}}
+	if got := tr.Completions([]rune("sh"), 5); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected completions\nGot: %v\nExpected: %v", got, expected)
+	}
+}